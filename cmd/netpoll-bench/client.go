@@ -0,0 +1,236 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+	"github.com/cloudwego/netpoll/mux"
+)
+
+// bench runs the client of cfg until cfg.duration, and reports the requests completed.
+func bench(cfg *config) (*report, error) {
+	var payload = make([]byte, cfg.size)
+	for i := range payload {
+		payload[i] = 'a' + byte(i%26)
+	}
+	var workers = make([]*worker, cfg.conns)
+	for i := range workers {
+		conn, err := netpoll.DialConnection("tcp", cfg.addr, time.Second)
+		if err != nil {
+			for _, w := range workers[:i] {
+				w.conn.Close()
+			}
+			return nil, err
+		}
+		workers[i] = newWorker(cfg, conn, payload)
+	}
+
+	var wg sync.WaitGroup
+	var start = time.Now()
+	var deadline = start.Add(cfg.duration)
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(deadline)
+		}(w)
+	}
+	wg.Wait()
+
+	var r = &report{elapsed: time.Since(start)}
+	for _, w := range workers {
+		r.latency = append(r.latency, w.latency...)
+		if w.err != nil {
+			r.errors++
+			r.lastErr = w.err
+		}
+		w.conn.Close()
+	}
+	if len(r.latency) == 0 {
+		return nil, fmt.Errorf("no request completed: %v", r.lastErr)
+	}
+	sort.Slice(r.latency, func(i, j int) bool { return r.latency[i] < r.latency[j] })
+	return r, nil
+}
+
+// worker keeps up to cfg.pipeline requests in flight on a connection, whose responses arrive in order.
+type worker struct {
+	cfg     *config
+	conn    netpoll.Connection
+	queue   *mux.ShardQueue
+	payload []byte
+	frame   []byte // the request written by the net.Conn API
+	resp    []byte // the response read by the net.Conn API
+	tokens  chan struct{}
+	sent    chan time.Time // the start time of requests in flight
+	stop    chan struct{}
+	latency []time.Duration
+	err     error
+}
+
+func newWorker(cfg *config, conn netpoll.Connection, payload []byte) *worker {
+	var w = &worker{
+		cfg:     cfg,
+		conn:    conn,
+		payload: payload,
+		tokens:  make(chan struct{}, cfg.pipeline),
+		sent:    make(chan time.Time, cfg.pipeline),
+		stop:    make(chan struct{}),
+	}
+	if cfg.mux {
+		w.queue = mux.NewShardQueue(mux.ShardSize, conn)
+	}
+	return w
+}
+
+func (w *worker) run(deadline time.Time) {
+	go w.sendLoop(deadline)
+	var seqID uint32
+	for start := range w.sent {
+		seqID++
+		if err := w.receive(seqID); err != nil {
+			w.err = err
+			close(w.stop)
+			return
+		}
+		w.latency = append(w.latency, time.Since(start))
+		<-w.tokens
+	}
+}
+
+func (w *worker) sendLoop(deadline time.Time) {
+	defer close(w.sent)
+	for seqID := uint32(1); time.Now().Before(deadline); seqID++ {
+		select {
+		case w.tokens <- struct{}{}:
+		case <-w.stop:
+			return
+		}
+		w.sent <- time.Now()
+		if err := w.send(seqID); err != nil {
+			// the receiver fails as well since the connection is closed.
+			w.conn.Close()
+			return
+		}
+	}
+}
+
+func (w *worker) send(seqID uint32) error {
+	var size = w.cfg.size
+	if w.cfg.scenario == scenarioRPC {
+		size += headerSize
+	}
+	if w.cfg.api == apiConn {
+		if w.frame == nil {
+			w.frame = make([]byte, size)
+			copy(w.frame[size-w.cfg.size:], w.payload)
+		}
+		w.encode(w.frame, seqID)
+		_, err := w.conn.Write(w.frame)
+		return err
+	}
+
+	var writer netpoll.Writer = w.conn.Writer()
+	if w.queue != nil {
+		writer = netpoll.NewLinkBuffer(size)
+	}
+	buf, err := writer.Malloc(size)
+	if err != nil {
+		return err
+	}
+	w.encode(buf, seqID)
+	copy(buf[size-w.cfg.size:], w.payload)
+	if w.queue != nil {
+		return w.queue.Add(func() (netpoll.Writer, bool) {
+			return writer, false
+		})
+	}
+	return writer.Flush()
+}
+
+// encode writes the header of rpc.
+func (w *worker) encode(buf []byte, seqID uint32) {
+	if w.cfg.scenario == scenarioRPC {
+		mux.DefaultFrameHeader.Encode(buf, seqID, w.cfg.size)
+	}
+}
+
+// receive reads the response of seqID.
+func (w *worker) receive(seqID uint32) (err error) {
+	var size = w.cfg.size
+	if w.cfg.scenario == scenarioRPC {
+		size += headerSize
+	}
+	var buf []byte
+	if w.cfg.api == apiConn {
+		if w.resp == nil {
+			w.resp = make([]byte, size)
+		}
+		buf = w.resp
+		_, err = io.ReadFull(w.conn, buf)
+	} else {
+		reader := w.conn.Reader()
+		defer reader.Release()
+		buf, err = reader.Next(size)
+	}
+	if err != nil || w.cfg.scenario != scenarioRPC {
+		return err
+	}
+	if got, _, _ := mux.DefaultFrameHeader.Decode(buf); got != seqID {
+		return fmt.Errorf("response of seqID %d, expect %d", got, seqID)
+	}
+	return nil
+}
+
+// report is the result of the client.
+type report struct {
+	elapsed time.Duration
+	latency []time.Duration // sorted
+	errors  int             // the connections failed
+	lastErr error
+}
+
+// percentile returns the latency at p, which is in [0, 1].
+func (r *report) percentile(p float64) time.Duration {
+	var i = int(float64(len(r.latency)) * p)
+	if i >= len(r.latency) {
+		i = len(r.latency) - 1
+	}
+	return r.latency[i]
+}
+
+func (r *report) print(out io.Writer, cfg *config) {
+	var sum time.Duration
+	for _, d := range r.latency {
+		sum += d
+	}
+	var n = len(r.latency)
+	fmt.Fprintf(out, "scenario=%s api=%s mux=%v size=%d conns=%d pipeline=%d pollers=%d\n",
+		cfg.scenario, cfg.api, cfg.mux, cfg.size, cfg.conns, cfg.pipeline, cfg.pollers)
+	fmt.Fprintf(out, "requests=%d elapsed=%v qps=%.0f errors=%d\n",
+		n, r.elapsed.Round(time.Millisecond), float64(n)/r.elapsed.Seconds(), r.errors)
+	fmt.Fprintf(out, "latency avg=%v p50=%v p90=%v p99=%v p999=%v max=%v\n",
+		sum/time.Duration(n), r.percentile(0.5), r.percentile(0.9), r.percentile(0.99), r.percentile(0.999),
+		r.latency[n-1])
+	if r.lastErr != nil {
+		fmt.Fprintln(out, "last error:", r.lastErr)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * netpoll-bench reproduces the QPS and latency benchmarks of netpoll on the local hardware.
+ *
+ * Run the server and the client in separate processes, or on separate machines:
+ *
+ *	netpoll-bench -mode server -addr :8888 -scenario rpc
+ *	netpoll-bench -mode client -addr 127.0.0.1:8888 -scenario rpc -size 1024 -conns 100 -pipeline 4 -duration 10s
+ *
+ * Scenarios:
+ *	echo: the server writes back the bytes as they arrive, and the client reads back the payload sent.
+ *	rpc: the payload is framed by the 8 bytes header of mux.DefaultFrameHeader, the server responds
+ *	     each complete request with the same body and sequence ID.
+ *
+ * The client keeps up to pipeline requests in flight on each connection, and reports QPS and the latency
+ * percentiles. Both sides use the nocopy Reader/Writer by default, or the net.Conn API by -api conn,
+ * and send by mux.ShardQueue with -mux.
+ */
+
+// config is the set of flags, which are shared by the server and the client.
+type config struct {
+	mode     string
+	addr     string
+	scenario string
+	api      string
+	size     int
+	conns    int
+	pipeline int
+	pollers  int
+	mux      bool
+	duration time.Duration
+}
+
+const (
+	scenarioEcho = "echo"
+	scenarioRPC  = "rpc"
+	apiNocopy    = "nocopy"
+	apiConn      = "conn"
+)
+
+func (c *config) validate() error {
+	switch {
+	case c.scenario != scenarioEcho && c.scenario != scenarioRPC:
+		return fmt.Errorf("unknown scenario %q", c.scenario)
+	case c.api != apiNocopy && c.api != apiConn:
+		return fmt.Errorf("unknown api %q", c.api)
+	case c.size <= 0:
+		return fmt.Errorf("invalid size %d", c.size)
+	case c.conns <= 0 || c.pipeline <= 0:
+		return fmt.Errorf("invalid conns %d or pipeline %d", c.conns, c.pipeline)
+	case c.mux && c.api != apiNocopy:
+		return fmt.Errorf("mux requires the nocopy api")
+	}
+	return nil
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.mode, "mode", "", "server or client")
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:8888", "address to listen or dial")
+	flag.StringVar(&cfg.scenario, "scenario", scenarioRPC, "echo or rpc")
+	flag.StringVar(&cfg.api, "api", apiNocopy, "nocopy for Reader/Writer, or conn for net.Conn")
+	flag.IntVar(&cfg.size, "size", 1024, "payload size in bytes")
+	flag.IntVar(&cfg.conns, "conns", 100, "number of connections of client")
+	flag.IntVar(&cfg.pipeline, "pipeline", 1, "requests in flight on each connection of client")
+	flag.IntVar(&cfg.pollers, "pollers", 0, "number of pollers, 0 means the default")
+	flag.BoolVar(&cfg.mux, "mux", false, "send by mux.ShardQueue")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "duration of client")
+	flag.Parse()
+
+	if err := run(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "netpoll-bench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if cfg.pollers > 0 {
+		if err := netpoll.SetNumLoops(cfg.pollers); err != nil {
+			return err
+		}
+	}
+	switch cfg.mode {
+	case "server":
+		loop, err := serve(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("serving %s on %s, api=%s mux=%v\n", cfg.scenario, cfg.addr, cfg.api, cfg.mux)
+		var sig = make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return loop.Shutdown(ctx)
+	case "client":
+		r, err := bench(cfg)
+		if err != nil {
+			return err
+		}
+		r.print(os.Stdout, cfg)
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, must be server or client", cfg.mode)
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	var cases = []config{
+		{scenario: scenarioEcho, api: apiNocopy},
+		{scenario: scenarioEcho, api: apiConn},
+		{scenario: scenarioEcho, api: apiNocopy, mux: true},
+		{scenario: scenarioRPC, api: apiNocopy},
+		{scenario: scenarioRPC, api: apiConn},
+		{scenario: scenarioRPC, api: apiNocopy, mux: true},
+	}
+	for _, cfg := range cases {
+		cfg.addr = "127.0.0.1:8922"
+		cfg.size, cfg.conns, cfg.pipeline = 4096, 4, 8
+		cfg.duration = 100 * time.Millisecond
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		loop, err := serve(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		r, err := bench(&cfg)
+		loop.Shutdown(context.Background())
+		if err != nil {
+			t.Fatalf("%s/%s/mux=%v: %v", cfg.scenario, cfg.api, cfg.mux, err)
+		}
+		if r.errors > 0 {
+			t.Fatalf("%s/%s/mux=%v: %v", cfg.scenario, cfg.api, cfg.mux, r.lastErr)
+		}
+		var out bytes.Buffer
+		r.print(&out, &cfg)
+		if !strings.Contains(out.String(), "p99=") {
+			t.Fatal(out.String())
+		}
+		t.Log(out.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var cfg = config{scenario: scenarioRPC, api: apiConn, size: 1, conns: 1, pipeline: 1, mux: true}
+	if cfg.validate() == nil {
+		t.Fatal("mux with net.Conn api must fail")
+	}
+	cfg.mux, cfg.scenario = false, "http"
+	if cfg.validate() == nil {
+		t.Fatal("unknown scenario must fail")
+	}
+}
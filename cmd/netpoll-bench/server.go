@@ -0,0 +1,128 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/cloudwego/netpoll"
+	"github.com/cloudwego/netpoll/mux"
+)
+
+const headerSize = 8
+
+type queueKey struct{}
+
+// serve starts the EventLoop of cfg.scenario in background.
+func serve(cfg *config) (netpoll.EventLoop, error) {
+	listener, err := netpoll.CreateListener("tcp", cfg.addr)
+	if err != nil {
+		return nil, err
+	}
+	var handler netpoll.OnRequest
+	switch {
+	case cfg.scenario == scenarioEcho && cfg.api == apiConn:
+		handler = echoConn
+	case cfg.scenario == scenarioEcho:
+		handler = echo
+	case cfg.api == apiConn:
+		handler = respondConn
+	default:
+		handler = respond
+	}
+	var opts []netpoll.Option
+	if cfg.mux {
+		opts = append(opts, netpoll.WithOnPrepare(func(connection netpoll.Connection) context.Context {
+			return context.WithValue(context.Background(), queueKey{}, mux.NewShardQueue(mux.ShardSize, connection))
+		}))
+	}
+	loop, err := netpoll.NewEventLoop(handler, opts...)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go loop.Serve(listener)
+	return loop, nil
+}
+
+// echo writes back the input.
+func echo(ctx context.Context, connection netpoll.Connection) error {
+	reader := connection.Reader()
+	data, err := reader.Next(reader.Len())
+	if err != nil {
+		return err
+	}
+	return reply(ctx, connection, data)
+}
+
+// respond writes back one request, whose header carries the same sequence ID and body size of response.
+func respond(ctx context.Context, connection netpoll.Connection) error {
+	reader := connection.Reader()
+	header, err := reader.Peek(headerSize)
+	if err != nil {
+		return err
+	}
+	_, size, _ := mux.DefaultFrameHeader.Decode(header)
+	frame, err := reader.Next(headerSize + size)
+	if err != nil {
+		return err
+	}
+	return reply(ctx, connection, frame)
+}
+
+// reply writes p read from the input, which is referenced until flushed without copying,
+// or copied when sent by ShardQueue asynchronously.
+func reply(ctx context.Context, connection netpoll.Connection, p []byte) error {
+	defer connection.Reader().Release()
+	if queue, ok := ctx.Value(queueKey{}).(*mux.ShardQueue); ok {
+		var buf = netpoll.NewLinkBuffer(len(p))
+		data, _ := buf.Malloc(len(p))
+		copy(data, p)
+		return queue.Add(func() (netpoll.Writer, bool) {
+			return buf, false
+		})
+	}
+	writer := connection.Writer()
+	writer.WriteBinary(p)
+	return writer.Flush()
+}
+
+// echoConn is echo by the net.Conn API.
+func echoConn(ctx context.Context, connection netpoll.Connection) error {
+	var buf = make([]byte, connection.Reader().Len())
+	n, err := connection.Read(buf)
+	if err != nil {
+		return err
+	}
+	_, err = connection.Write(buf[:n])
+	return err
+}
+
+// respondConn is respond by the net.Conn API.
+func respondConn(ctx context.Context, connection netpoll.Connection) error {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(connection, header[:]); err != nil {
+		return err
+	}
+	_, size, _ := mux.DefaultFrameHeader.Decode(header[:])
+	var frame = make([]byte, headerSize+size)
+	copy(frame, header[:])
+	if _, err := io.ReadFull(connection, frame[headerSize:]); err != nil {
+		return err
+	}
+	_, err := connection.Write(frame)
+	return err
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	// MaxBulkLen is the maximum length of bulk strings, the same as the default proto-max-bulk-len of Redis.
+	MaxBulkLen = 512 << 20
+	// maxAggregateLen is the maximum number of elements of aggregates.
+	maxAggregateLen = 1 << 24
+	// maxLineLen is the maximum length of the lines except bulk strings.
+	maxLineLen = 64 << 10
+	// maxDepth is the maximum depth of nested aggregates.
+	maxDepth = 64
+	// minPeekSize is the minimum size peeked at once, to avoid peeking the lines byte by byte.
+	minPeekSize = 512
+)
+
+var crlf = []byte("\r\n")
+
+// NewDecoder creates a Decoder reading from r, which must not be shared with other Decoders.
+func NewDecoder(r netpoll.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decoder decodes RESP values from a Reader.
+type Decoder struct {
+	r netpoll.Reader
+	// the minimum size of data required by the incomplete value,
+	// so that a large value is not parsed again until it's possible to complete.
+	need int
+}
+
+// Decode reads the first value of the Reader. If the value has not been received completely, it returns
+// ErrIncomplete and nothing is consumed, so it should be called again when more data arrives.
+// The slices in the value are valid until the Reader is released.
+func (d *Decoder) Decode() (v Value, err error) {
+	var l = d.r.Len()
+	if l == 0 || l < d.need {
+		return v, ErrIncomplete
+	}
+	var pr = parser{r: d.r}
+	if err = pr.parse(&v, 0); err != nil {
+		if err == ErrIncomplete {
+			d.need = pr.need
+		}
+		return Value{}, err
+	}
+	d.need = 0
+	return v, d.r.Skip(pr.off)
+}
+
+// parser parses a value from the Reader, which peeks the data incrementally as the value is parsed,
+// so the data following the value is not peeked.
+type parser struct {
+	r    netpoll.Reader
+	p    []byte // the data peeked
+	off  int    // the offset parsed
+	need int    // the minimum size of data required if incomplete
+}
+
+// peek makes at least n bytes peeked, or returns ErrIncomplete if not received yet.
+// The size peeked grows geometrically, so the data is copied in linear time if it's in several nodes,
+// and the slices peeked before are still valid until the Reader is released.
+func (pr *parser) peek(n int) (err error) {
+	if n <= len(pr.p) {
+		return nil
+	}
+	var l = pr.r.Len()
+	if n > l {
+		pr.need = n
+		return ErrIncomplete
+	}
+	if size := 2 * len(pr.p); n < size {
+		n = size
+	}
+	if n < minPeekSize {
+		n = minPeekSize
+	}
+	if n > l {
+		n = l
+	}
+	pr.p, err = pr.r.Peek(n)
+	return err
+}
+
+func (pr *parser) parse(v *Value, depth int) (err error) {
+	if depth > maxDepth {
+		return fmt.Errorf("%w: too deep nesting", ErrProtocol)
+	}
+	line, err := pr.line()
+	if err != nil {
+		return err
+	}
+	v.Type = Type(line[0])
+	line = line[1:]
+	switch v.Type {
+	case SimpleString, Error, Double, BigNumber:
+		v.Str = line
+	case Integer:
+		if v.Int, err = strconv.ParseInt(string(line), 10, 64); err != nil {
+			return fmt.Errorf("%w: invalid integer %q", ErrProtocol, line)
+		}
+	case Null:
+		v.IsNull = true
+	case Boolean:
+		switch string(line) {
+		case "t":
+			v.Int = 1
+		case "f":
+			v.Int = 0
+		default:
+			return fmt.Errorf("%w: invalid boolean %q", ErrProtocol, line)
+		}
+	case BulkString, BulkError, VerbatimString:
+		n, err := pr.length(line, v.Type == BulkString, MaxBulkLen)
+		if err != nil || n < 0 {
+			v.IsNull = n < 0
+			return err
+		}
+		var end = pr.off + n + len(crlf)
+		if err = pr.peek(end); err != nil {
+			return err
+		}
+		if !bytes.Equal(pr.p[end-len(crlf):end], crlf) {
+			return fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+		}
+		v.Str = pr.p[pr.off : pr.off+n]
+		pr.off = end
+	case Array, Set, Push, Map, Attribute:
+		n, err := pr.length(line, v.Type == Array, maxAggregateLen)
+		if err != nil || n < 0 {
+			v.IsNull = n < 0
+			return err
+		}
+		if v.Type == Map || v.Type == Attribute {
+			n *= 2
+		}
+		// each element takes 3 bytes at least, check it before allocating
+		if end := pr.off + n*len("_\r\n"); end > pr.r.Len() {
+			pr.need = end
+			return ErrIncomplete
+		}
+		v.Elems = make([]Value, n)
+		for i := range v.Elems {
+			if err = pr.parse(&v.Elems[i], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrProtocol, byte(v.Type))
+	}
+	return nil
+}
+
+// line returns the next line without CRLF, which contains the type at least.
+func (pr *parser) line() (line []byte, err error) {
+	var from = pr.off
+	var i int
+	for {
+		if i = bytes.Index(pr.p[from:], crlf); i >= 0 {
+			i += from - pr.off
+			break
+		}
+		if len(pr.p)-pr.off > maxLineLen {
+			return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		// scan the data peeked only once, except the last byte which may be CR
+		if len(pr.p) > from {
+			from = len(pr.p) - 1
+		}
+		if err = pr.peek(len(pr.p) + 1); err != nil {
+			return nil, err
+		}
+	}
+	if i == 0 {
+		return nil, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+	line = pr.p[pr.off : pr.off+i]
+	pr.off += i + len(crlf)
+	return line, nil
+}
+
+// length parses the length of bulk strings or aggregates, -1 means null if nullable.
+func (pr *parser) length(line []byte, nullable bool, max int) (n int, err error) {
+	if nullable && string(line) == "-1" {
+		return -1, nil
+	}
+	n64, err := strconv.ParseUint(string(line), 10, 31)
+	if err != nil || int(n64) > max {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, line)
+	}
+	return int(n64), nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"strconv"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// NewEncoder creates an Encoder writing to w.
+func NewEncoder(w netpoll.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encoder encodes RESP values into the space allocated by Writer.Malloc, which are sent by Flush.
+type Encoder struct {
+	w netpoll.Writer
+}
+
+// WriteCommand writes the command as an array of bulk strings, e.g. SET key value.
+func (e *Encoder) WriteCommand(args ...[]byte) error {
+	var size = headerLen(len(args))
+	for _, arg := range args {
+		size += headerLen(len(arg)) + len(arg) + len(crlf)
+	}
+	buf, err := e.w.Malloc(size)
+	if err != nil {
+		return err
+	}
+	var n = len(appendHeader(buf[:0], Array, len(args)))
+	for _, arg := range args {
+		n += putBulk(buf[n:], BulkString, arg)
+	}
+	return nil
+}
+
+// WriteArrayHeader writes the header of an array of n elements, which should be written following.
+func (e *Encoder) WriteArrayHeader(n int) error {
+	return e.writeHeader(Array, n)
+}
+
+// WriteMapHeader writes the header of a RESP3 map of n key-value pairs, which should be written following.
+func (e *Encoder) WriteMapHeader(n int) error {
+	return e.writeHeader(Map, n)
+}
+
+// WriteBulk writes p as a bulk string.
+func (e *Encoder) WriteBulk(p []byte) error {
+	buf, err := e.w.Malloc(headerLen(len(p)) + len(p) + len(crlf))
+	if err != nil {
+		return err
+	}
+	putBulk(buf, BulkString, p)
+	return nil
+}
+
+// WriteBulkString writes s as a bulk string.
+func (e *Encoder) WriteBulkString(s string) error {
+	buf, err := e.w.Malloc(headerLen(len(s)) + len(s) + len(crlf))
+	if err != nil {
+		return err
+	}
+	var n = len(appendHeader(buf[:0], BulkString, len(s)))
+	n += copy(buf[n:], s)
+	copy(buf[n:], crlf)
+	return nil
+}
+
+// WriteSimpleString writes s as a simple string, which must not contain CR or LF.
+func (e *Encoder) WriteSimpleString(s string) error {
+	return e.writeLine(SimpleString, s)
+}
+
+// WriteError writes s as an error, e.g. "ERR unknown command", which must not contain CR or LF.
+func (e *Encoder) WriteError(s string) error {
+	return e.writeLine(Error, s)
+}
+
+// WriteInteger writes n as an integer.
+func (e *Encoder) WriteInteger(n int64) error {
+	var num [20]byte
+	var digits = strconv.AppendInt(num[:0], n, 10)
+	buf, err := e.w.Malloc(1 + len(digits) + len(crlf))
+	if err != nil {
+		return err
+	}
+	buf[0] = byte(Integer)
+	copy(buf[1+copy(buf[1:], digits):], crlf)
+	return nil
+}
+
+// WriteNull writes the null bulk string of RESP2, which is compatible with all clients.
+func (e *Encoder) WriteNull() error {
+	_, err := e.w.WriteString("$-1\r\n")
+	return err
+}
+
+// WriteValue writes v, the RESP3 types should only be written if the client has switched by HELLO 3.
+func (e *Encoder) WriteValue(v *Value) (err error) {
+	switch v.Type {
+	case SimpleString, Error, Double, BigNumber:
+		return e.writeLine(v.Type, string(v.Str))
+	case Integer:
+		return e.WriteInteger(v.Int)
+	case Null:
+		_, err = e.w.WriteString("_\r\n")
+		return err
+	case Boolean:
+		if v.Int != 0 {
+			_, err = e.w.WriteString("#t\r\n")
+		} else {
+			_, err = e.w.WriteString("#f\r\n")
+		}
+		return err
+	case BulkString, BulkError, VerbatimString:
+		if v.IsNull {
+			return e.WriteNull()
+		}
+		buf, err := e.w.Malloc(headerLen(len(v.Str)) + len(v.Str) + len(crlf))
+		if err != nil {
+			return err
+		}
+		putBulk(buf, v.Type, v.Str)
+		return nil
+	case Array, Set, Push, Map, Attribute:
+		if v.IsNull {
+			_, err = e.w.WriteString("*-1\r\n")
+			return err
+		}
+		var n = len(v.Elems)
+		if v.Type == Map || v.Type == Attribute {
+			n /= 2
+		}
+		if err = e.writeHeader(v.Type, n); err != nil {
+			return err
+		}
+		for i := range v.Elems {
+			if err = e.WriteValue(&v.Elems[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrProtocol
+}
+
+// Flush sends the values written.
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *Encoder) writeHeader(typ Type, n int) error {
+	buf, err := e.w.Malloc(headerLen(n))
+	if err != nil {
+		return err
+	}
+	appendHeader(buf[:0], typ, n)
+	return nil
+}
+
+func (e *Encoder) writeLine(typ Type, s string) error {
+	buf, err := e.w.Malloc(1 + len(s) + len(crlf))
+	if err != nil {
+		return err
+	}
+	buf[0] = byte(typ)
+	copy(buf[1+copy(buf[1:], s):], crlf)
+	return nil
+}
+
+// headerLen returns the size of the header with length n, e.g. "$5\r\n".
+func headerLen(n int) int {
+	var digits = 1
+	for ; n >= 10; n /= 10 {
+		digits++
+	}
+	return 1 + digits + len(crlf)
+}
+
+// putBulk puts the bulk string p into buf, and returns the size.
+func putBulk(buf []byte, typ Type, p []byte) (n int) {
+	n = len(appendHeader(buf[:0], typ, len(p)))
+	n += copy(buf[n:], p)
+	return n + copy(buf[n:], crlf)
+}
+
+// appendHeader appends the header to b, which is written in place since the space is allocated by Malloc.
+func appendHeader(b []byte, typ Type, n int) []byte {
+	return append(strconv.AppendInt(append(b, byte(typ)), int64(n), 10), crlf...)
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"errors"
+	"strconv"
+)
+
+/* DOC:
+ * Package resp implements the codec of RESP2/RESP3 (REdis Serialization Protocol) on netpoll Reader and Writer.
+ *
+ * Decoder.Decode: decode a value incrementally, which consumes nothing and returns ErrIncomplete if the value
+ * has not been received completely. The strings of values are zero-copy slices of the input buffer
+ * (copied once if the value spans nodes), which are only valid until the Reader is released.
+ * Encoder: write values directly into the space allocated by Writer.Malloc, and send them by Flush.
+ *
+ * The streamed aggregates of RESP3 and the inline commands are not supported.
+ */
+
+// Type is the type of RESP value, which is the first byte of its encoding.
+type Type byte
+
+// RESP2 types.
+const (
+	SimpleString Type = '+'
+	Error        Type = '-'
+	Integer      Type = ':'
+	BulkString   Type = '$'
+	Array        Type = '*'
+)
+
+// RESP3 types.
+const (
+	Null           Type = '_'
+	Boolean        Type = '#'
+	Double         Type = ','
+	BigNumber      Type = '('
+	BulkError      Type = '!'
+	VerbatimString Type = '='
+	Map            Type = '%'
+	Set            Type = '~'
+	Attribute      Type = '|'
+	Push           Type = '>'
+)
+
+// ErrIncomplete is returned by Decoder.Decode if the value has not been received completely.
+var ErrIncomplete = errors.New("resp: incomplete value")
+
+// ErrProtocol is returned if the data received is not valid RESP.
+var ErrProtocol = errors.New("resp: protocol error")
+
+// Value is a RESP value.
+type Value struct {
+	Type Type
+	// Str is the content of SimpleString, Error, BulkString, BulkError, VerbatimString, Double and BigNumber.
+	// It's a zero-copy slice when decoded, which is only valid until the Reader is released.
+	Str []byte
+	// Int is the number of Integer, and 1 or 0 of Boolean.
+	Int int64
+	// Elems are the elements of Array, Set and Push, or the key-value pairs in order of Map and Attribute.
+	Elems []Value
+	// IsNull reports the null bulk string and null array of RESP2, or the Null of RESP3.
+	IsNull bool
+}
+
+// String returns the content of Str as a string, which is copied.
+func (v Value) String() string {
+	return string(v.Str)
+}
+
+// Float returns the number of Double.
+func (v Value) Float() (float64, error) {
+	return strconv.ParseFloat(string(v.Str), 64)
+}
@@ -0,0 +1,253 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func newTestBuffer(data string) *netpoll.LinkBuffer {
+	var buf = netpoll.NewLinkBuffer()
+	buf.WriteString(data)
+	buf.Flush()
+	return buf
+}
+
+func TestDecode(t *testing.T) {
+	var buf = newTestBuffer("+OK\r\n-ERR bad\r\n:-42\r\n$5\r\nhello\r\n$0\r\n\r\n$-1\r\n*-1\r\n" +
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" +
+		"_\r\n#t\r\n,3.14\r\n(12345678901234567890\r\n!3\r\nERR\r\n=7\r\ntxt:abc\r\n" +
+		"%1\r\n+k\r\n~2\r\n:1\r\n:2\r\n>1\r\n*0\r\n")
+	var d = NewDecoder(buf)
+	var next = func(typ Type) Value {
+		t.Helper()
+		v, err := d.Decode()
+		MustNil(t, err)
+		Equal(t, v.Type, typ)
+		return v
+	}
+	Equal(t, next(SimpleString).String(), "OK")
+	Equal(t, next(Error).String(), "ERR bad")
+	Equal(t, next(Integer).Int, int64(-42))
+	Equal(t, next(BulkString).String(), "hello")
+	v := next(BulkString)
+	MustTrue(t, !v.IsNull && len(v.Str) == 0)
+	MustTrue(t, next(BulkString).IsNull)
+	MustTrue(t, next(Array).IsNull)
+	v = next(Array)
+	Equal(t, len(v.Elems), 2)
+	Equal(t, v.Elems[0].String(), "GET")
+	Equal(t, v.Elems[1].String(), "key")
+
+	MustTrue(t, next(Null).IsNull)
+	Equal(t, next(Boolean).Int, int64(1))
+	v = next(Double)
+	f, err := v.Float()
+	MustNil(t, err)
+	Equal(t, f, 3.14)
+	Equal(t, next(BigNumber).String(), "12345678901234567890")
+	Equal(t, next(BulkError).String(), "ERR")
+	Equal(t, next(VerbatimString).String(), "txt:abc")
+	v = next(Map)
+	Equal(t, len(v.Elems), 2)
+	Equal(t, v.Elems[0].String(), "k")
+	Equal(t, v.Elems[1].Type, Set)
+	Equal(t, v.Elems[1].Elems[1].Int, int64(2))
+	v = next(Push)
+	Equal(t, v.Elems[0].Type, Array)
+	Equal(t, buf.Len(), 0)
+
+	_, err = d.Decode()
+	Equal(t, err, ErrIncomplete)
+}
+
+func TestDecodeIncomplete(t *testing.T) {
+	var data = "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nvalue\r\n"
+	var buf = netpoll.NewLinkBuffer()
+	var d = NewDecoder(buf)
+	for i := 0; i < len(data); i++ {
+		_, err := d.Decode()
+		Equal(t, err, ErrIncomplete)
+		Equal(t, buf.Len(), i)
+		buf.WriteString(data[i : i+1])
+		buf.Flush()
+	}
+	v, err := d.Decode()
+	MustNil(t, err)
+	Equal(t, v.Elems[2].String(), "value")
+	Equal(t, buf.Len(), 0)
+
+	// the large bulk is not parsed again until it's possible to complete
+	buf.WriteString("$100\r\n" + strings.Repeat("x", 10))
+	buf.Flush()
+	_, err = d.Decode()
+	Equal(t, err, ErrIncomplete)
+	Equal(t, d.need, 108)
+	buf.WriteString(strings.Repeat("x", 90) + "\r\n")
+	buf.Flush()
+	v, err = d.Decode()
+	MustNil(t, err)
+	Equal(t, len(v.Str), 100)
+
+	// the array larger than the data received
+	buf.WriteString("*1000\r\n:1\r\n")
+	buf.Flush()
+	_, err = d.Decode()
+	Equal(t, err, ErrIncomplete)
+	Equal(t, d.need, 7+3000)
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	var buf = netpoll.NewLinkBuffer(1024)
+	buf.WriteString("$5\r\nhello\r\n")
+	buf.Flush()
+	p, err := buf.Peek(buf.Len())
+	MustNil(t, err)
+	v, err := NewDecoder(buf).Decode()
+	MustNil(t, err)
+	MustTrue(t, &v.Str[0] == &p[4])
+	MustNil(t, buf.Release())
+}
+
+// peekRecorder records the maximum size peeked.
+type peekRecorder struct {
+	netpoll.Reader
+	max int
+}
+
+func (r *peekRecorder) Peek(n int) ([]byte, error) {
+	if n > r.max {
+		r.max = n
+	}
+	return r.Reader.Peek(n)
+}
+
+func TestDecodePeekBounded(t *testing.T) {
+	// many values in separate nodes, only the first one is peeked
+	var buf = netpoll.NewLinkBuffer()
+	for i := 0; i < 1000; i++ {
+		buf.WriteBuffer(newTestBuffer("$1000\r\n" + strings.Repeat("x", 1000) + "\r\n"))
+	}
+	buf.Flush()
+	var r = &peekRecorder{Reader: buf}
+	var d = NewDecoder(r)
+	for i := 0; i < 3; i++ {
+		r.max = 0
+		v, err := d.Decode()
+		MustNil(t, err)
+		Equal(t, len(v.Str), 1000)
+		Assert(t, r.max <= 2*1007, r.max)
+	}
+	// the line across nodes
+	buf = netpoll.NewLinkBuffer()
+	for _, s := range []string{"+hel", "lo\r", "\n:1\r\n"} {
+		buf.WriteBuffer(newTestBuffer(s))
+	}
+	buf.Flush()
+	d = NewDecoder(buf)
+	v, err := d.Decode()
+	MustNil(t, err)
+	Equal(t, v.String(), "hello")
+	v, err = d.Decode()
+	MustNil(t, err)
+	Equal(t, v.Int, int64(1))
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, s := range []string{
+		"?\r\n",
+		"\r\n",
+		":abc\r\n",
+		"#x\r\n",
+		"$-2\r\n",
+		"$3\r\nabcd\r\n",
+		"%-1\r\n",
+		"*1\r\n" + strings.Repeat("*1\r\n", 100) + ":1\r\n",
+		"+" + strings.Repeat("a", maxLineLen+1),
+	} {
+		_, err := NewDecoder(newTestBuffer(s)).Decode()
+		Assert(t, errors.Is(err, ErrProtocol), s, err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf = netpoll.NewLinkBuffer()
+	var e = NewEncoder(buf)
+	MustNil(t, e.WriteCommand([]byte("SET"), []byte("key"), []byte(strings.Repeat("v", 12))))
+	MustNil(t, e.WriteArrayHeader(2))
+	MustNil(t, e.WriteBulk([]byte("a")))
+	MustNil(t, e.WriteBulkString(""))
+	MustNil(t, e.WriteSimpleString("OK"))
+	MustNil(t, e.WriteError("ERR bad"))
+	MustNil(t, e.WriteInteger(-1234567890))
+	MustNil(t, e.WriteNull())
+	MustNil(t, e.WriteMapHeader(1))
+	MustNil(t, e.Flush())
+	s, err := buf.ReadString(buf.Len())
+	MustNil(t, err)
+	Equal(t, s, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$12\r\nvvvvvvvvvvvv\r\n*2\r\n$1\r\na\r\n$0\r\n\r\n"+
+		"+OK\r\n-ERR bad\r\n:-1234567890\r\n$-1\r\n%1\r\n")
+
+	// round trip
+	var data = "*-1\r\n%2\r\n+k\r\n#f\r\n$3\r\nkey\r\n~1\r\n=7\r\ntxt:abc\r\n>2\r\n_\r\n,1.5\r\n"
+	var d = NewDecoder(newTestBuffer(data))
+	for i := 0; i < 2; i++ {
+		v, err := d.Decode()
+		MustNil(t, err)
+		MustNil(t, e.WriteValue(&v))
+	}
+	MustNil(t, e.Flush())
+	s, err = buf.ReadString(buf.Len())
+	MustNil(t, err)
+	Equal(t, s, data[:strings.Index(data, ">")])
+}
@@ -15,6 +15,7 @@
 package netpoll
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -64,3 +65,86 @@ type Connection interface {
 	// to polling check connection status.
 	AddCloseCallback(callback CloseCallback) error
 }
+
+// FDConnection is implemented by the connections of unix stream sockets, such as the ones dialed
+// by DialUnix and accepted from the unix listeners, which can pass file descriptors and credentials
+// as ancillary data. Connections of other networks return ErrUnsupported.
+//
+// The file descriptors are sent along with the data, and received when the data is read into the input buffer,
+// so they are available no later than the data they are sent with.
+type FDConnection interface {
+	Connection
+
+	// WriteFDs sends fds as SCM_RIGHTS along with data, which must not be empty.
+	// The data written before but not flushed is not sent, and the caller still owns fds after return.
+	WriteFDs(fds []int, data []byte) (n int, err error)
+
+	// ReadFDs returns the file descriptors received so far, and the caller owns them,
+	// which are close-on-exec and need to be closed when no longer used.
+	// The file descriptors not taken are closed with the connection.
+	ReadFDs() (fds []int)
+
+	// SetPassCred enables receiving SCM_CREDENTIALS of the peer, only supported on Linux.
+	SetPassCred(enable bool) error
+
+	// ReadCredentials returns the latest credentials received, nil means not received.
+	ReadCredentials() (cred *UnixCredentials)
+}
+
+// DisconnectNotifier is implemented by all the connections created by netpoll, which is kept out of Connection
+// for compatibility with the other implementations. Check it by type assertion:
+//
+//	if notifier, ok := conn.(netpoll.DisconnectNotifier); ok {
+//		notifier.SetOnDisconnect(onDisconnect)
+//	}
+type DisconnectNotifier interface {
+	// SetOnDisconnect sets the OnDisconnect called when the poller finds the connection is closed by the peer.
+	SetOnDisconnect(onDisconnect OnDisconnect) error
+}
+
+// OnPacket defines the function for handling datagrams received by PacketConnection.
+// Like OnRequest, OnPacket will run in a separate goroutine and it is guaranteed that
+// there is one and only one OnPacket running at the same time, which is called once for each datagram.
+//
+// PLEASE NOTE:
+// packet is only valid during OnPacket, and will be released after OnPacket returns.
+//
+// Return: error is unused which will be ignored directly.
+type OnPacket func(ctx context.Context, conn PacketConnection, packet Reader, addr net.Addr) error
+
+// PacketConnection is a packet-oriented connection registered on the pollers, such as a UDP socket.
+// It maintains its own input buffer, and provides nocopy API for reading and writing datagrams.
+type PacketConnection interface {
+	// PacketConnection extends net.PacketConn, just for interface compatibility.
+	// It's not recommended to use net.PacketConn API except for io.Closer.
+	net.PacketConn
+
+	// ReadPacket is the nocopy version of ReadFrom, which returns the next datagram and its source address.
+	// It will be blocked until a datagram arrives, or returns an error after timeout which set by SetReadTimeout.
+	// The returned Reader can be used until it is released.
+	ReadPacket() (packet Reader, addr net.Addr, err error)
+
+	// WritePacket is the nocopy version of WriteTo, which sends all the data submitted to w as a single datagram.
+	// w must be a *LinkBuffer, and can't be used after calling WritePacket.
+	WritePacket(w Writer, addr net.Addr) (n int, err error)
+
+	// QueuePacket is the batched version of WritePacket, which queues all the data submitted to w as a single datagram,
+	// and the datagram will be sent after calling Flush. The datagrams queued during OnPacket are flushed automatically.
+	// w must be a *LinkBuffer, and can't be used after calling QueuePacket.
+	QueuePacket(w Writer, addr net.Addr) (n int, err error)
+
+	// Flush sends all the queued datagrams in batches, and waits for the socket to be writable if its buffer is full.
+	// If the kernel supports, datagrams of the same size to the same address will be merged by UDP GSO.
+	Flush() (err error)
+
+	// IsActive checks whether the connection is active or not.
+	IsActive() bool
+
+	// SetReadTimeout sets the timeout for future ReadPacket calls wait.
+	// A zero value for timeout means ReadPacket will not timeout.
+	SetReadTimeout(timeout time.Duration) error
+
+	// SetOnPacket can set or replace the OnPacket method for a connection, but can't be set to nil.
+	// Once OnPacket is set, all the datagrams will be delivered to it instead of ReadPacket.
+	SetOnPacket(on OnPacket) error
+}
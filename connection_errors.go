@@ -28,11 +28,15 @@ const (
 	// dial timeout
 	ErrDialTimeout = syscall.Errno(0x103)
 	// Calling dialer without timeout.
-	ErrDialNoDeadline = syscall.Errno(0x104) // TODO: no-deadline support in future
+	//
+	// Deprecated: dialing without timeout is supported, and waits until connected, failed or canceled.
+	ErrDialNoDeadline = syscall.Errno(0x104)
 	// The calling function not support.
 	ErrUnsupported = syscall.Errno(0x105)
 	// Same as io.EOF
 	ErrEOF = syscall.Errno(0x106)
+	// The frame exceeds the maximum size of FrameDecoder.
+	ErrFrameTooLarge = syscall.Errno(0x107)
 )
 
 const ErrnoMask = 0xFF
@@ -94,4 +98,5 @@ var errnos = [...]string{
 	ErrnoMask & ErrDialNoDeadline: "dial no deadline",
 	ErrnoMask & ErrUnsupported:    "netpoll dose not support",
 	ErrnoMask & ErrEOF:            "EOF",
+	ErrnoMask & ErrFrameTooLarge:  "frame too large",
 }
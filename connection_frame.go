@@ -0,0 +1,263 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// FrameDecoder finds the boundary of frames in the input buffer of connection,
+// so that OnRequest is only scheduled when a complete frame has been received.
+type FrameDecoder interface {
+	// Decode returns the size of the first frame in r, or 0 if the frame is not complete yet.
+	// It must only peek r without consuming any data, and an error closes the connection.
+	Decode(r Reader) (size int, err error)
+}
+
+var (
+	errInvalidFrameLength = errors.New("invalid frame length")
+	errInvalidLengthField = errors.New("invalid length field")
+	errEmptyDelimiter     = errors.New("empty delimiter")
+)
+
+// NewLengthFieldDecoder creates a FrameDecoder of frames which carry the length in a header field.
+// The field is at offset of the frame, and is an unsigned integer of width bytes (1, 2, 4 or 8) in order.
+// The frame size is offset + width + length + adjustment, e.g. the adjustment is -(offset+width)
+// if the length counts the whole frame. A frame larger than maxFrameSize fails with ErrFrameTooLarge,
+// and 0 means no limit.
+func NewLengthFieldDecoder(offset, width int, order binary.ByteOrder, adjustment, maxFrameSize int) (FrameDecoder, error) {
+	switch width {
+	case 1, 2, 4, 8:
+	default:
+		return nil, errInvalidLengthField
+	}
+	if offset < 0 || order == nil {
+		return nil, errInvalidLengthField
+	}
+	return &lengthFieldDecoder{
+		offset:       offset,
+		width:        width,
+		order:        order,
+		adjustment:   adjustment,
+		maxFrameSize: maxFrameSize,
+	}, nil
+}
+
+type lengthFieldDecoder struct {
+	offset, width int
+	order         binary.ByteOrder
+	adjustment    int
+	maxFrameSize  int
+}
+
+// Decode implements FrameDecoder.
+func (d *lengthFieldDecoder) Decode(r Reader) (size int, err error) {
+	var header = d.offset + d.width
+	if r.Len() < header {
+		return 0, nil
+	}
+	p, err := r.Peek(header)
+	if err != nil {
+		return 0, err
+	}
+	p = p[d.offset:]
+	var length uint64
+	switch d.width {
+	case 1:
+		length = uint64(p[0])
+	case 2:
+		length = uint64(d.order.Uint16(p))
+	case 4:
+		length = uint64(d.order.Uint32(p))
+	case 8:
+		length = d.order.Uint64(p)
+	}
+	// avoid overflow of int
+	if length > uint64(^uint(0)>>2) {
+		return 0, Exception(ErrFrameTooLarge, "")
+	}
+	size = header + int(length) + d.adjustment
+	if size < header {
+		return 0, errInvalidFrameLength
+	}
+	if d.maxFrameSize > 0 && size > d.maxFrameSize {
+		return 0, Exception(ErrFrameTooLarge, "")
+	}
+	if r.Len() < size {
+		return 0, nil
+	}
+	return size, nil
+}
+
+// NewDelimiterDecoder creates a FrameDecoder of frames which end with delim, such as "\r\n".
+// The frame returned includes the delimiter. A frame larger than maxFrameSize fails with ErrFrameTooLarge,
+// and 0 means no limit.
+func NewDelimiterDecoder(delim []byte, maxFrameSize int) (FrameDecoder, error) {
+	if len(delim) == 0 {
+		return nil, errEmptyDelimiter
+	}
+	return &delimiterDecoder{
+		delim:        append([]byte{}, delim...),
+		maxFrameSize: maxFrameSize,
+	}, nil
+}
+
+type delimiterDecoder struct {
+	delim        []byte
+	maxFrameSize int
+}
+
+// resumableDecoder is implemented by the FrameDecoder which can resume decoding from the bytes scanned last time,
+// so that the input buffer is not rescanned from the start each time more data is received.
+type resumableDecoder interface {
+	// decodeFrom is like Decode, but skips the first from bytes which have been scanned,
+	// and returns the bytes scanned if the frame is not complete.
+	decodeFrom(r Reader, from int) (size, scanned int, err error)
+}
+
+// Decode implements FrameDecoder.
+func (d *delimiterDecoder) Decode(r Reader) (size int, err error) {
+	size, _, err = d.decodeFrom(r, 0)
+	return size, err
+}
+
+// decodeFrom implements resumableDecoder.
+func (d *delimiterDecoder) decodeFrom(r Reader, from int) (size, scanned int, err error) {
+	var l = r.Len()
+	if l < len(d.delim) {
+		return 0, 0, nil
+	}
+	var idx int
+	if b, ok := r.(*LinkBuffer); ok {
+		// scan the nodes without copying
+		idx = b.indexDelim(d.delim, from)
+	} else {
+		p, err := r.Peek(l)
+		if err != nil {
+			return 0, 0, err
+		}
+		var start = from - len(d.delim) + 1
+		if start < 0 {
+			start = 0
+		}
+		if idx = bytes.Index(p[start:], d.delim); idx >= 0 {
+			idx += start
+		}
+	}
+	if idx < 0 {
+		// a frame within the limit must have been found
+		if d.maxFrameSize > 0 && l >= d.maxFrameSize {
+			return 0, 0, Exception(ErrFrameTooLarge, "")
+		}
+		return 0, l, nil
+	}
+	size = idx + len(d.delim)
+	if d.maxFrameSize > 0 && size > d.maxFrameSize {
+		return 0, 0, Exception(ErrFrameTooLarge, "")
+	}
+	return size, 0, nil
+}
+
+// frameState caches the decoding progress of the input buffer, since both readable and OnRequest decode
+// the first frame, which may be called concurrently when the task is exiting.
+type frameState struct {
+	mu      sync.Mutex
+	size    int // size of the first frame, 0 if it's not complete
+	scanned int // bytes scanned by resumableDecoder without finding the first frame
+}
+
+// decodeFrame returns the size of the first frame in the input buffer, or 0 if it's not complete yet.
+func (c *connection) decodeFrame() (size int, err error) {
+	var fs = &c.frame
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.size > 0 {
+		return fs.size, nil
+	}
+	if d, ok := c.decoder.(resumableDecoder); ok {
+		size, fs.scanned, err = d.decodeFrom(c.inputBuffer, fs.scanned)
+	} else {
+		size, err = c.decoder.Decode(c.inputBuffer)
+	}
+	fs.size = size
+	return size, err
+}
+
+// frameConsumed resets the decoding progress after the first frame has been sliced.
+func (c *connection) frameConsumed() {
+	c.frame.mu.Lock()
+	c.frame.size, c.frame.scanned = 0, 0
+	c.frame.mu.Unlock()
+}
+
+// frameConnection is the Connection passed to OnRequest, whose Reader is the frame decoded.
+type frameConnection struct {
+	Connection
+	frame Reader
+}
+
+// Reader implements Connection.
+func (c *frameConnection) Reader() Reader {
+	return c.frame
+}
+
+// wrapFrameDecoder makes the OnRequest of connection be called with one complete frame each time,
+// which must be called in OnPrepare. The connection is closed if the decoder fails.
+func wrapFrameDecoder(conn Connection, decoder FrameDecoder) {
+	var c, ok = conn.(*connection)
+	if !ok {
+		return
+	}
+	var process, _ = c.process.Load().(OnRequest)
+	if process == nil {
+		return
+	}
+	c.decoder = decoder
+	var onRequest OnRequest = func(ctx context.Context, connection Connection) error {
+		size, err := c.decodeFrame()
+		if err != nil {
+			c.Close()
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		frame, err := c.Slice(size)
+		c.frameConsumed()
+		if err != nil {
+			return err
+		}
+		// the frame is only valid in OnRequest
+		defer frame.Release()
+		return process(ctx, &frameConnection{Connection: connection, frame: frame})
+	}
+	c.process.Store(onRequest)
+}
+
+// readable reports whether OnRequest should be called, that is there is a complete frame
+// or the decoder fails if decoder is set, otherwise any data has been received.
+func (c *connection) readable() bool {
+	// the PROXY protocol header is parsed before decoding frames
+	if c.decoder == nil || c.proxy != nil && atomic.LoadInt32(&c.proxy.state) == 0 {
+		return c.inputBuffer.Len() > 0
+	}
+	size, err := c.decodeFrame()
+	return size > 0 || err != nil
+}
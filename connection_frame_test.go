@@ -0,0 +1,218 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLengthFieldDecoder(t *testing.T) {
+	var decode = func(d FrameDecoder, data []byte) (int, error) {
+		var buf = NewLinkBuffer()
+		buf.WriteBinary(data)
+		buf.Flush()
+		return d.Decode(buf)
+	}
+
+	// 2 bytes magic + uint16 length of body
+	var d, err = NewLengthFieldDecoder(2, 2, binary.BigEndian, 0, 0)
+	MustNil(t, err)
+	size, err := decode(d, []byte{0xCA, 0xFE, 0, 3, 'a', 'b', 'c', 'x'})
+	MustNil(t, err)
+	Equal(t, size, 7)
+	size, err = decode(d, []byte{0xCA, 0xFE, 0, 3, 'a', 'b'})
+	MustNil(t, err)
+	Equal(t, size, 0)
+	size, err = decode(d, []byte{0xCA, 0xFE, 0})
+	MustNil(t, err)
+	Equal(t, size, 0)
+
+	// little endian uint32 length of the whole frame
+	d, _ = NewLengthFieldDecoder(0, 4, binary.LittleEndian, -4, 0)
+	size, err = decode(d, []byte{6, 0, 0, 0, 'a', 'b', 'c'})
+	MustNil(t, err)
+	Equal(t, size, 6)
+	_, err = decode(d, []byte{2, 0, 0, 0})
+	MustTrue(t, err != nil)
+
+	// max frame size
+	d, _ = NewLengthFieldDecoder(0, 1, binary.BigEndian, 0, 8)
+	size, err = decode(d, []byte{7})
+	MustNil(t, err)
+	Equal(t, size, 0)
+	_, err = decode(d, []byte{8})
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+	d, _ = NewLengthFieldDecoder(0, 8, binary.BigEndian, 0, 1024)
+	_, err = decode(d, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+
+	// invalid arguments
+	_, err = NewLengthFieldDecoder(0, 3, binary.BigEndian, 0, 0)
+	MustTrue(t, err != nil)
+	_, err = NewLengthFieldDecoder(-1, 2, binary.BigEndian, 0, 0)
+	MustTrue(t, err != nil)
+}
+
+func TestDelimiterDecoder(t *testing.T) {
+	// append each data as a separate node
+	var write = func(buf *LinkBuffer, ss ...string) {
+		for _, s := range ss {
+			var node = NewLinkBuffer()
+			node.WriteString(s)
+			node.Flush()
+			buf.WriteBuffer(node)
+		}
+		buf.Flush()
+	}
+	_, err := NewDelimiterDecoder(nil, 0)
+	MustTrue(t, err != nil)
+	d, err := NewDelimiterDecoder([]byte("\r\n"), 16)
+	MustNil(t, err)
+	var buf = NewLinkBuffer()
+	write(buf, "hello\r")
+	size, err := d.Decode(buf)
+	MustNil(t, err)
+	Equal(t, size, 0)
+
+	// the delimiter across nodes
+	write(buf, "\nworld\r\n")
+	size, err = d.Decode(buf)
+	MustNil(t, err)
+	Equal(t, size, 7)
+	MustNil(t, buf.Skip(size))
+	size, err = d.Decode(buf)
+	MustNil(t, err)
+	Equal(t, size, 7)
+
+	// max frame size
+	buf = NewLinkBuffer()
+	buf.WriteString(strings.Repeat("a", 15) + "\r\n")
+	buf.Flush()
+	_, err = d.Decode(buf)
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+	buf = NewLinkBuffer()
+	buf.WriteString(strings.Repeat("a", 16))
+	buf.Flush()
+	_, err = d.Decode(buf)
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+
+	// the delimiter longer than a node
+	d, _ = NewDelimiterDecoder([]byte("--end--"), 0)
+	buf = NewLinkBuffer()
+	write(buf, "ab-", "-e", "n", "d--", "x")
+	size, err = d.Decode(buf)
+	MustNil(t, err)
+	Equal(t, size, 9)
+
+	// resume scanning from the bytes scanned, the delimiter may start before them
+	var rd = d.(resumableDecoder)
+	buf = NewLinkBuffer()
+	write(buf, "abc--en")
+	size, scanned, err := rd.decodeFrom(buf, 0)
+	MustNil(t, err)
+	Equal(t, size, 0)
+	Equal(t, scanned, 7)
+	write(buf, "d", "--x")
+	size, _, err = rd.decodeFrom(buf, scanned)
+	MustNil(t, err)
+	Equal(t, size, 10)
+}
+
+func TestFrameDecoder(t *testing.T) {
+	var network, address = "tcp", ":8913"
+	var frames = make(chan string, 16)
+	var lengthDecoder, err = NewLengthFieldDecoder(0, 1, binary.BigEndian, 0, 8)
+	MustNil(t, err)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			reader := connection.Reader()
+			frame, err := reader.ReadString(reader.Len())
+			if err != nil {
+				return err
+			}
+			frames <- frame
+			connection.Writer().WriteString(frame)
+			return connection.Writer().Flush()
+		},
+		WithFrameDecoder(lengthDecoder))
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	// split one frame into several writes
+	for _, s := range []string{"\x05he", "ll", "o"} {
+		conn.Writer().WriteString(s)
+		MustNil(t, conn.Writer().Flush())
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, <-frames, "\x05hello")
+	// several frames in one write
+	conn.Writer().WriteString("\x01a\x02bc\x00")
+	MustNil(t, conn.Writer().Flush())
+	Equal(t, <-frames, "\x01a")
+	Equal(t, <-frames, "\x02bc")
+	Equal(t, <-frames, "\x00")
+	s, err := conn.Reader().ReadString(12)
+	MustNil(t, err)
+	Equal(t, s, "\x05hello\x01a\x02bc\x00")
+	Equal(t, len(frames), 0)
+
+	// the connection is closed if the frame is too large
+	conn.Writer().WriteString("\x09")
+	MustNil(t, conn.Writer().Flush())
+	_, err = conn.Reader().ReadString(1)
+	MustTrue(t, err != nil)
+}
+
+func TestFrameDecoderDelimiter(t *testing.T) {
+	var network, address = "tcp", ":8927"
+	var frames = make(chan string, 16)
+	var lineDecoder, err = NewDelimiterDecoder([]byte("\r\n"), 0)
+	MustNil(t, err)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			reader := connection.Reader()
+			frame, err := reader.ReadString(reader.Len())
+			if err != nil {
+				return err
+			}
+			frames <- frame
+			return nil
+		},
+		WithFrameDecoder(lineDecoder))
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	// the scanning resumes as more data is received
+	for _, s := range []string{"hel", "lo\r", "\nab\r\nc", "d\r", "\n"} {
+		conn.Writer().WriteString(s)
+		MustNil(t, conn.Writer().Flush())
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, <-frames, "hello\r\n")
+	Equal(t, <-frames, "ab\r\n")
+	Equal(t, <-frames, "cd\r\n")
+	Equal(t, len(frames), 0)
+}
@@ -43,11 +43,20 @@ type connection struct {
 	supportZeroCopy bool
 	maxSize         int // The maximum size of data between two Release().
 	bookSize        int // The size of data that can be read at once.
+	oobMux          sync.Mutex
+	rights          []int            // file descriptors received by unix socket
+	credentials     *UnixCredentials // credentials received by unix socket
+	proxy           *proxyState      // state of parsing PROXY protocol header
+	decoder         FrameDecoder     // decoder of frames passed to OnRequest
+	frame           frameState       // decoding progress of decoder
+	created         time.Time
+	poll            Poll // the poller registered, which outlives the operator freed on close
 }
 
 var _ Connection = &connection{}
 var _ Reader = &connection{}
 var _ Writer = &connection{}
+var _ DisconnectNotifier = &connection{}
 
 // Reader implements Connection.
 func (c *connection) Reader() Reader {
@@ -275,6 +284,7 @@ func (c *connection) init(conn Conn, prepare OnPrepare) (err error) {
 	c.readTrigger = make(chan struct{}, 1)
 	c.writeTrigger = make(chan error, 1)
 	c.bookSize, c.maxSize = block1k/2, pagesize
+	c.created = time.Now()
 	c.inputBuffer, c.outputBuffer = NewLinkBuffer(pagesize), NewLinkBuffer()
 	c.inputBarrier, c.outputBarrier = barrierPool.Get().(*barrier), barrierPool.Get().(*barrier)
 	c.setFinalizer()
@@ -309,6 +319,11 @@ func (c *connection) initFDOperator() {
 	op.OnRead, op.OnWrite, op.OnHup = nil, nil, c.onHup
 	op.Inputs, op.InputAck = c.inputs, c.inputAck
 	op.Outputs, op.OutputAck = c.outputs, c.outputAck
+	// unix stream socket can pass file descriptors and credentials as ancillary data.
+	if c.network == "unix" {
+		op.OOB = make([]byte, unixOOBSize)
+		op.InputOOB = c.inputOOB
+	}
 
 	// if connection has been registered, must reuse poll here.
 	if c.pd != nil && c.pd.operator != nil {
@@ -322,11 +337,26 @@ func (c *connection) setFinalizer() {
 		c.stop(flushing)
 		c.netFD.Close()
 		c.closeBuffer()
+		c.closeRights()
 		freeop(c.operator)
 		return nil
 	})
 }
 
+// info returns the running status of connection.
+func (c *connection) info(now time.Time) ConnectionInfo {
+	return ConnectionInfo{
+		FD:         c.fd,
+		LocalAddr:  c.LocalAddr(),
+		RemoteAddr: c.RemoteAddr(),
+		Poller:     pollmanager.index(c.poll),
+		InputLen:   c.inputBuffer.Len(),
+		OutputLen:  c.outputBuffer.Len(),
+		Processing: !c.isUnlock(processing),
+		Age:        now.Sub(c.created),
+	}
+}
+
 func (c *connection) triggerRead() {
 	select {
 	case c.readTrigger <- struct{}{}:
@@ -410,7 +440,7 @@ func (c *connection) waitReadWithTimeout(n int) (err error) {
 func (c *connection) fill(need int) (err error) {
 	var n int
 	for {
-		n, err = readv(c.fd, c.inputs(c.inputBarrier.bs), c.inputBarrier.ivs)
+		n, err = c.operator.readv(c.inputs(c.inputBarrier.bs), c.inputBarrier.ivs)
 		c.inputAck(n)
 		if n < pagesize || err != nil {
 			break
@@ -43,9 +43,10 @@ type gracefulExit interface {
 // OnPrepare, OnRequest, CloseCallback share the lock processing,
 // which is a CAS lock and can only be cleared by OnRequest.
 type onEvent struct {
-	ctx       context.Context
-	process   atomic.Value // value is OnRequest
-	callbacks atomic.Value // value is latest *callbackNode
+	ctx        context.Context
+	process    atomic.Value // value is OnRequest
+	callbacks  atomic.Value // value is latest *callbackNode
+	disconnect atomic.Value // value is OnDisconnect
 }
 
 type callbackNode struct {
@@ -61,6 +62,14 @@ func (on *onEvent) SetOnRequest(onReq OnRequest) error {
 	return nil
 }
 
+// SetOnDisconnect implements DisconnectNotifier.
+func (on *onEvent) SetOnDisconnect(onDisconnect OnDisconnect) error {
+	if onDisconnect != nil {
+		on.disconnect.Store(onDisconnect)
+	}
+	return nil
+}
+
 // AddCloseCallback adds a CloseCallback to this connection.
 func (on *onEvent) AddCloseCallback(callback CloseCallback) error {
 	if callback == nil {
@@ -99,6 +108,15 @@ func (c *connection) onRequest() (needTrigger bool) {
 	if !c.lock(processing) {
 		return true
 	}
+	// wait for a complete frame
+	if c.decoder != nil && !c.readable() {
+		c.unlock(processing)
+		// the callbacks skipped by closing meanwhile
+		if !c.IsActive() && c.lock(processing) {
+			c.closeCallback(false)
+		}
+		return true
+	}
 	// add new task
 	var task = func() {
 		if c.ctx == nil {
@@ -107,7 +125,7 @@ func (c *connection) onRequest() (needTrigger bool) {
 		var handler = process.(OnRequest)
 	START:
 		// NOTE: loop processing, which is useful for streaming.
-		for c.Reader().Len() > 0 && c.IsActive() {
+		for c.readable() && c.IsActive() {
 			// Single request processing, blocking allowed.
 			handler(c.ctx, c)
 		}
@@ -118,7 +136,7 @@ func (c *connection) onRequest() (needTrigger bool) {
 		}
 		c.unlock(processing)
 		// Double check when exiting.
-		if c.Reader().Len() > 0 {
+		if c.readable() {
 			if !c.lock(processing) {
 				return
 			}
@@ -159,9 +177,33 @@ func (c *connection) register() (err error) {
 		c.Close()
 		return Exception(ErrConnClosed, err.Error())
 	}
+	c.poll = c.operator.poll
 	return nil
 }
 
+// detach removes the idle connection from the poller without closing it, which is used to hand off.
+// Return false if the connection is busy.
+func (c *connection) detach() (ok bool) {
+	if !c.lock(processing) {
+		return false
+	}
+	defer c.unlock(processing)
+	// stop the poller reading more data before detached.
+	if !c.lock(reading) {
+		return false
+	}
+	defer c.unlock(reading)
+	if !c.IsActive() || !c.inputBuffer.IsEmpty() || !c.outputBuffer.IsEmpty() {
+		return false
+	}
+	return c.operator.Control(PollDetach) == nil
+}
+
+// attach registers the detached connection back to the poller, which is used when hand off failed.
+func (c *connection) attach() error {
+	return c.operator.Control(PollReadable)
+}
+
 // isIdle implements gracefulExit.
 func (c *connection) isIdle() (yes bool) {
 	return c.isUnlock(processing) &&
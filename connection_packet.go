@@ -0,0 +1,604 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxPacketSize is the maximum size of a datagram.
+	maxPacketSize = 64 * block1k
+	// packetNodeSize is the size of LinkBuffer node used to read datagrams,
+	// which can hold at least one whole datagram.
+	packetNodeSize = 2 * maxPacketSize
+	// maxPacketsPerRead limits the datagrams read in one poll event, to avoid starving other fds.
+	maxPacketsPerRead = 4 * barriercap
+	// defaultPacketBatchSize is the default number of datagrams read or written by one system call.
+	defaultPacketBatchSize = 8
+	// maxGSOSegments is the maximum number of datagrams can be merged by UDP GSO.
+	maxGSOSegments = 64
+	// maxGSOSize is the maximum size of datagrams merged by UDP GSO, which must fit in an IP packet.
+	maxGSOSize = 65507
+)
+
+// packet is the datagram received, which data is stored in inputBuffer.
+type packet struct {
+	size int
+	addr net.Addr
+}
+
+// outPacket is the datagram queued by QueuePacket.
+type outPacket struct {
+	buf *LinkBuffer
+	to  syscall.Sockaddr
+}
+
+// packetMsg is the datagram used by batch I/O.
+type packetMsg struct {
+	buf     []byte           // buffer for receiving
+	bs      [][]byte         // data for sending
+	ivs     []syscall.Iovec  // iovecs for sending
+	addr    net.Addr         // source address of the received datagram
+	to      syscall.Sockaddr // destination address of the sending datagram
+	segment int              // segment size of UDP GRO/GSO, 0 means not segmented
+	n       int              // bytes received or sent
+	cover   int              // number of queued datagrams merged into this message
+}
+
+// packetConnection is the implement of PacketConnection.
+type packetConnection struct {
+	netFD
+	locker
+	ctx          context.Context
+	process      atomic.Value // value is OnPacket
+	operator     *FDOperator
+	opts         packetOptions
+	gro, gso     bool // whether UDP GRO/GSO is enabled
+	readTimeout  time.Duration
+	readTrigger  chan struct{}
+	writeTrigger chan error
+	inputBuffer  *LinkBuffer
+	mux          sync.Mutex // guards packets
+	packets      []packet
+	recvIO       *batchIO // only used by poller
+	recvs        []packetMsg
+	received     []packet
+	outMux       sync.Mutex // guards outputs
+	outputs      []outPacket
+	flushMux     sync.Mutex // guards sendIO and sends
+	sendIO       *batchIO
+	sends        []packetMsg
+}
+
+var _ PacketConnection = &packetConnection{}
+
+// newPacketConnection registers the datagram socket into poll.
+func newPacketConnection(conn Conn, opts ...PacketOption) (*packetConnection, error) {
+	var c = &packetConnection{}
+	if nfd, ok := conn.(*netFD); ok {
+		c.netFD = *nfd
+	} else {
+		c.netFD = netFD{
+			fd:        conn.Fd(),
+			localAddr: conn.LocalAddr(),
+		}
+	}
+	if c.sotype == 0 {
+		c.sotype = syscall.SOCK_DGRAM
+	}
+	if c.family == 0 {
+		c.family = syscall.AF_INET
+		if sa, _ := syscall.Getsockname(c.fd); sa != nil {
+			if _, ok := sa.(*syscall.SockaddrInet6); ok {
+				c.family = syscall.AF_INET6
+			}
+		}
+	}
+	syscall.SetNonblock(c.fd, true)
+
+	c.opts = packetOptions{
+		batchSize:  defaultPacketBatchSize,
+		packetSize: maxPacketSize,
+	}
+	for _, do := range opts {
+		do.f(&c.opts)
+	}
+	// GRO may merge datagrams up to 64KB, which requires enough buffer.
+	if c.opts.packetSize >= maxPacketSize {
+		c.gro = enableUDPGRO(c.fd)
+	}
+	c.gso = supportUDPGSO(c.fd)
+	c.recvIO, c.sendIO = newBatchIO(c.opts.batchSize), newBatchIO(c.opts.batchSize)
+	c.recvs, c.sends = make([]packetMsg, c.opts.batchSize), make([]packetMsg, c.opts.batchSize)
+	for i := range c.recvs {
+		c.recvs[i].buf = make([]byte, c.opts.packetSize)
+		c.sends[i].bs = make([][]byte, barriercap)
+		c.sends[i].ivs = make([]syscall.Iovec, barriercap)
+	}
+
+	c.ctx = context.Background()
+	c.readTrigger = make(chan struct{}, 1)
+	c.writeTrigger = make(chan error, 1)
+	c.inputBuffer = NewLinkBuffer()
+
+	op := allocop()
+	op.FD = c.fd
+	op.OnRead, op.OnWrite, op.OnHup = c.onRead, c.onWrite, c.onHup
+	op.poll = pollmanager.Pick()
+	c.operator = op
+	if err := op.Control(PollReadable); err != nil {
+		log.Println("packet connection register failed:", err.Error())
+		c.netFD.Close()
+		c.inputBuffer.Close()
+		freeop(op)
+		return nil, err
+	}
+	return c, nil
+}
+
+// IsActive implements PacketConnection.
+func (c *packetConnection) IsActive() bool {
+	return c.isCloseBy(none)
+}
+
+// SetReadTimeout implements PacketConnection.
+func (c *packetConnection) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		c.readTimeout = timeout
+	}
+	return nil
+}
+
+// SetOnPacket implements PacketConnection.
+func (c *packetConnection) SetOnPacket(on OnPacket) error {
+	if on != nil {
+		c.process.Store(on)
+		// deliver the datagrams that have been received before.
+		if c.pending() > 0 {
+			c.onPacket()
+		}
+	}
+	return nil
+}
+
+// ReadPacket implements PacketConnection.
+func (c *packetConnection) ReadPacket() (p Reader, addr net.Addr, err error) {
+	pkt, err := c.waitPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err = c.inputBuffer.Slice(pkt.size)
+	return p, pkt.addr, err
+}
+
+// WritePacket implements PacketConnection.
+func (c *packetConnection) WritePacket(w Writer, addr net.Addr) (n int, err error) {
+	var buf, ok = w.(*LinkBuffer)
+	if !ok {
+		return 0, errors.New("unsupported writer which is not LinkBuffer")
+	}
+	defer buf.Close()
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write packet")
+	}
+	sa, err := udpAddrToSockaddr(c.family, addr)
+	if err != nil {
+		return 0, err
+	}
+	buf.Flush()
+	var bar = barrierPool.Get().(*barrier)
+	defer barrierPool.Put(bar)
+	var bs = buf.GetBytes(bar.bs)
+	for i := range bs {
+		n += len(bs[i])
+	}
+	// too many chunks, copy into one.
+	if n < buf.Len() {
+		bs = [][]byte{buf.Bytes()}
+	}
+	n, err = sendmsgto(c.fd, bs, bar.ivs, sa)
+	if err != nil {
+		return 0, Exception(err, "when write packet")
+	}
+	return n, nil
+}
+
+// QueuePacket implements PacketConnection.
+func (c *packetConnection) QueuePacket(w Writer, addr net.Addr) (n int, err error) {
+	var buf, ok = w.(*LinkBuffer)
+	if !ok {
+		return 0, errors.New("unsupported writer which is not LinkBuffer")
+	}
+	if !c.IsActive() {
+		buf.Close()
+		return 0, Exception(ErrConnClosed, "when queue packet")
+	}
+	sa, err := udpAddrToSockaddr(c.family, addr)
+	if err != nil {
+		buf.Close()
+		return 0, err
+	}
+	buf.Flush()
+	n = buf.Len()
+	c.outMux.Lock()
+	c.outputs = append(c.outputs, outPacket{buf: buf, to: sa})
+	c.outMux.Unlock()
+	return n, nil
+}
+
+// Flush implements PacketConnection.
+func (c *packetConnection) Flush() (err error) {
+	c.flushMux.Lock()
+	defer c.flushMux.Unlock()
+	c.outMux.Lock()
+	var outs = c.outputs
+	c.outputs = nil
+	c.outMux.Unlock()
+	if len(outs) == 0 {
+		return nil
+	}
+	for len(outs) > 0 && err == nil {
+		if !c.IsActive() {
+			err = ErrConnClosed
+			break
+		}
+		var sent int
+		sent, err = c.sendIO.send(c.fd, c.sends[:c.pack(outs)])
+		var consumed int
+		for i := 0; i < sent; i++ {
+			consumed += c.sends[i].cover
+		}
+		for i := 0; i < consumed; i++ {
+			outs[i].buf.Close()
+			outs[i] = outPacket{}
+		}
+		outs = outs[consumed:]
+		// wait until the socket buffer is writable, like connection.flush.
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			err = c.waitWrite()
+		}
+	}
+	if err != nil {
+		for i := range outs {
+			outs[i].buf.Close()
+		}
+		return Exception(err, fmt.Sprintf("when flush, %d packets dropped", len(outs)))
+	}
+	return nil
+}
+
+// ReadFrom implements net.PacketConn, the bytes exceeding len(p) will be discarded.
+func (c *packetConnection) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	pkt, addr, err := c.ReadPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	n = pkt.Len()
+	if n > len(p) {
+		n = len(p)
+	}
+	src, err := pkt.Next(n)
+	n = copy(p, src)
+	pkt.Release()
+	return n, addr, err
+}
+
+// WriteTo implements net.PacketConn.
+func (c *packetConnection) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write packet")
+	}
+	sa, err := udpAddrToSockaddr(c.family, addr)
+	if err != nil {
+		return 0, err
+	}
+	if err = syscall.Sendto(c.fd, p, 0, sa); err != nil {
+		return 0, Exception(err, "when write packet")
+	}
+	return len(p), nil
+}
+
+// Close implements PacketConnection.
+func (c *packetConnection) Close() error {
+	if c.closeBy(user) {
+		c.operator.Control(PollDetach)
+		c.triggerRead()
+		c.triggerWrite(ErrConnClosed)
+		c.release(true)
+	}
+	return nil
+}
+
+// ------------------------------------------ private ------------------------------------------
+
+// onRead implements FDOperator, which reads the datagrams into inputBuffer in batches.
+func (c *packetConnection) onRead(p Poll) error {
+	var pkts = c.received[:0]
+	for len(pkts) < maxPacketsPerRead {
+		var n, err = c.recvIO.recv(c.fd, c.recvs, c.gro)
+		if err != nil {
+			if err != syscall.EAGAIN && err != syscall.EINTR {
+				log.Printf("recvmmsg(fd=%d) failed: %s", c.fd, err.Error())
+			}
+			break
+		}
+		for i := 0; i < n; i++ {
+			pkts = c.store(&c.recvs[i], pkts)
+		}
+		// socket buffer has been drained
+		if n < len(c.recvs) {
+			break
+		}
+	}
+	c.received = pkts
+	if len(pkts) == 0 {
+		return nil
+	}
+	c.inputBuffer.Flush()
+	c.push(pkts...)
+	c.onPacket()
+	return nil
+}
+
+// store writes the received datagram into inputBuffer, and splits it if it has been merged by UDP GRO.
+func (c *packetConnection) store(msg *packetMsg, pkts []packet) []packet {
+	// copy the datagram, so that the receiving buffer can be reused.
+	var p, _ = c.inputBuffer.Malloc(msg.n)
+	copy(p, msg.buf[:msg.n])
+	if msg.segment <= 0 || msg.segment >= msg.n {
+		return append(pkts, packet{size: msg.n, addr: msg.addr})
+	}
+	for off := 0; off < msg.n; off += msg.segment {
+		var size = msg.segment
+		if off+size > msg.n {
+			size = msg.n - off
+		}
+		pkts = append(pkts, packet{size: size, addr: msg.addr})
+	}
+	return pkts
+}
+
+// pack fills the sending messages with the queued datagrams, merging them by UDP GSO if possible,
+// and returns the number of messages.
+func (c *packetConnection) pack(outs []outPacket) (msgs int) {
+	var i int
+	for ; i < len(outs) && msgs < len(c.sends); msgs++ {
+		var msg = &c.sends[msgs]
+		var bs = msg.bs[:cap(msg.bs)]
+		msg.to, msg.segment, msg.cover, msg.n = outs[i].to, 0, 1, 0
+		var size = outs[i].buf.Len()
+		var k = gather(bs, 0, outs[i].buf)
+		if k < 0 {
+			// too many chunks, copy into one.
+			bs[0], k = outs[i].buf.Bytes(), 1
+		}
+		i++
+		// Only the last datagram merged can be smaller than the segment size.
+		for c.gso && size > 0 && i < len(outs) && msg.cover < maxGSOSegments {
+			var next = outs[i].buf.Len()
+			if next == 0 || next > size || (msg.cover+1)*size > maxGSOSize || !sameSockaddr(msg.to, outs[i].to) {
+				break
+			}
+			var nk = gather(bs, k, outs[i].buf)
+			if nk < 0 {
+				break
+			}
+			k, msg.cover, i = nk, msg.cover+1, i+1
+			if next < size {
+				break
+			}
+		}
+		if msg.cover > 1 {
+			msg.segment = size
+		}
+		msg.bs = bs[:k]
+	}
+	return msgs
+}
+
+// gather puts the readable data of buf into bs[k:], and returns the number of used chunks in bs,
+// -1 means bs has not enough space.
+func gather(bs [][]byte, k int, buf *LinkBuffer) int {
+	if k >= len(bs) {
+		return -1
+	}
+	var vs = buf.GetBytes(bs[k:])
+	var l int
+	for i := range vs {
+		l += len(vs[i])
+	}
+	if l < buf.Len() {
+		return -1
+	}
+	return k + len(vs)
+}
+
+// sameSockaddr checks whether the two addresses are the same.
+func sameSockaddr(a, b syscall.Sockaddr) bool {
+	switch a := a.(type) {
+	case *syscall.SockaddrInet4:
+		b, ok := b.(*syscall.SockaddrInet4)
+		return ok && a.Port == b.Port && a.Addr == b.Addr
+	case *syscall.SockaddrInet6:
+		b, ok := b.(*syscall.SockaddrInet6)
+		return ok && a.Port == b.Port && a.ZoneId == b.ZoneId && a.Addr == b.Addr
+	}
+	return false
+}
+
+// onWrite implements FDOperator, which wakes up the Flush waiting for writability.
+func (c *packetConnection) onWrite(p Poll) error {
+	c.operator.Control(PollRW2R)
+	c.triggerWrite(nil)
+	return nil
+}
+
+// onHup implements FDOperator.
+func (c *packetConnection) onHup(p Poll) error {
+	if c.closeBy(poller) {
+		c.triggerRead()
+		c.triggerWrite(ErrConnClosed)
+		c.release(true)
+	}
+	return nil
+}
+
+// onPacket delivers the datagrams to OnPacket, or wakes up ReadPacket if OnPacket is not set.
+func (c *packetConnection) onPacket() {
+	var handler, _ = c.process.Load().(OnPacket)
+	if handler == nil {
+		c.triggerRead()
+		return
+	}
+	// task already exists
+	if !c.lock(processing) {
+		return
+	}
+	var task = func() {
+	START:
+		for c.IsActive() {
+			pkt, ok := c.pop()
+			if !ok {
+				break
+			}
+			p, _ := c.inputBuffer.Slice(pkt.size)
+			handler(c.ctx, c, p, pkt.addr)
+			p.Release()
+		}
+		// send the datagrams queued by OnPacket in batches.
+		c.Flush()
+		// release resources if connection has been closed.
+		if !c.IsActive() {
+			c.release(false)
+			return
+		}
+		c.unlock(processing)
+		// Double check when exiting.
+		if c.pending() > 0 {
+			if !c.lock(processing) {
+				return
+			}
+			goto START
+		}
+	}
+	runTask(c.ctx, task)
+}
+
+// release recycles all the resources.
+// It can be confirmed that release and OnPacket will not be executed concurrently.
+// If OnPacket is still running, it will trigger release on exit.
+func (c *packetConnection) release(needLock bool) {
+	if needLock && !c.lock(processing) {
+		return
+	}
+	// wait for the Flush in progress, which may still use the fd and the operator.
+	// It's woken up by triggerWrite and exits since the connection is closed.
+	c.flushMux.Lock()
+	c.netFD.Close()
+	freeop(c.operator)
+	c.flushMux.Unlock()
+	c.inputBuffer.Close()
+	// drop the datagrams not sent.
+	c.outMux.Lock()
+	for i := range c.outputs {
+		c.outputs[i].buf.Close()
+	}
+	c.outputs = nil
+	c.outMux.Unlock()
+}
+
+func (c *packetConnection) triggerRead() {
+	select {
+	case c.readTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (c *packetConnection) triggerWrite(err error) {
+	select {
+	case c.writeTrigger <- err:
+	default:
+	}
+}
+
+// waitWrite monitors the write event, and waits until the socket is writable or closed.
+func (c *packetConnection) waitWrite() error {
+	if err := c.operator.Control(PollR2RW); err != nil {
+		return err
+	}
+	return <-c.writeTrigger
+}
+
+// waitPacket will wait a datagram or until timeout.
+func (c *packetConnection) waitPacket() (pkt packet, err error) {
+	var timer *time.Timer
+	if c.readTimeout > 0 {
+		timer = time.NewTimer(c.readTimeout)
+		defer timer.Stop()
+	}
+	for {
+		if !c.IsActive() {
+			return pkt, Exception(ErrConnClosed, "wait read")
+		}
+		if pkt, ok := c.pop(); ok {
+			return pkt, nil
+		}
+		if timer == nil {
+			<-c.readTrigger
+			continue
+		}
+		select {
+		case <-timer.C:
+			if pkt, ok := c.pop(); ok {
+				return pkt, nil
+			}
+			return pkt, Exception(ErrReadTimeout, c.localAddr.String())
+		case <-c.readTrigger:
+		}
+	}
+}
+
+func (c *packetConnection) push(pkts ...packet) {
+	c.mux.Lock()
+	c.packets = append(c.packets, pkts...)
+	c.mux.Unlock()
+}
+
+func (c *packetConnection) pop() (pkt packet, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.packets) == 0 {
+		return pkt, false
+	}
+	pkt = c.packets[0]
+	c.packets[0] = packet{}
+	c.packets = c.packets[1:]
+	return pkt, true
+}
+
+func (c *packetConnection) pending() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.packets)
+}
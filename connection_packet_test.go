@@ -0,0 +1,185 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketConnectionEcho(t *testing.T) {
+	pconn, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer pconn.Close()
+	pconn.SetOnPacket(func(ctx context.Context, conn PacketConnection, packet Reader, addr net.Addr) error {
+		// echo by nocopy api
+		var buf = NewLinkBuffer()
+		var p, _ = packet.Next(packet.Len())
+		buf.WriteBinary(p)
+		_, err := conn.WritePacket(buf, addr)
+		return err
+	})
+
+	conn, err := DialConnection("udp", pconn.LocalAddr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	_, ok := conn.RemoteAddr().(*net.UDPAddr)
+	MustTrue(t, ok)
+
+	// each flush is a datagram, and the datagrams are read in order.
+	var msgs = []string{"hello", "netpoll", "udp"}
+	for _, msg := range msgs {
+		_, err = conn.Writer().WriteString(msg)
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+	}
+	for _, msg := range msgs {
+		s, err := conn.Reader().ReadString(len(msg))
+		MustNil(t, err)
+		Equal(t, s, msg)
+	}
+	MustNil(t, conn.Reader().Release())
+}
+
+func TestPacketConnectionReadPacket(t *testing.T) {
+	pconn, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer pconn.Close()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	var big = make([]byte, 32*1024)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	_, err = client.WriteTo([]byte("ping"), pconn.LocalAddr())
+	MustNil(t, err)
+	_, err = client.WriteTo(big, pconn.LocalAddr())
+	MustNil(t, err)
+
+	p, addr, err := pconn.ReadPacket()
+	MustNil(t, err)
+	Equal(t, addr.String(), client.LocalAddr().String())
+	Equal(t, p.Len(), 4)
+	s, _ := p.ReadString(4)
+	Equal(t, s, "ping")
+	p.Release()
+
+	var recv = make([]byte, 64*1024)
+	n, _, err := pconn.ReadFrom(recv)
+	MustNil(t, err)
+	Equal(t, n, len(big))
+	Equal(t, string(recv[:n]), string(big))
+
+	n, err = pconn.WriteTo([]byte("pong"), client.LocalAddr())
+	MustNil(t, err)
+	Equal(t, n, 4)
+	n, _, err = client.ReadFrom(recv)
+	MustNil(t, err)
+	Equal(t, string(recv[:n]), "pong")
+
+	// read timeout
+	pconn.SetReadTimeout(10 * time.Millisecond)
+	_, _, err = pconn.ReadPacket()
+	MustTrue(t, errors.Is(err, ErrReadTimeout))
+
+	// closed
+	MustNil(t, pconn.Close())
+	MustTrue(t, !pconn.IsActive())
+	_, _, err = pconn.ReadPacket()
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+}
+
+func TestPacketConnectionBatch(t *testing.T) {
+	pconn, err := ListenPacket("udp", "127.0.0.1:0", WithBatchSize(4))
+	MustNil(t, err)
+	defer pconn.Close()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	// datagrams of the same size may be merged by GSO, and the last one can be smaller.
+	var sizes = []int{1024, 1024, 1024, 1024, 1024, 100, 8 * 1024, 8 * 1024, 5}
+	for i, size := range sizes {
+		var buf = NewLinkBuffer()
+		p, _ := buf.Malloc(size)
+		for j := range p {
+			p[j] = byte(i)
+		}
+		n, err := pconn.QueuePacket(buf, client.LocalAddr())
+		MustNil(t, err)
+		Equal(t, n, size)
+	}
+	MustNil(t, pconn.Flush())
+
+	var recv = make([]byte, 64*1024)
+	for i, size := range sizes {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := client.ReadFrom(recv)
+		MustNil(t, err)
+		Equal(t, n, size)
+		for j := 0; j < n; j++ {
+			Assert(t, recv[j] == byte(i), i, j)
+		}
+	}
+
+	// the received datagrams are read in batches and order.
+	for _, size := range sizes {
+		_, err = client.WriteTo(make([]byte, size), pconn.LocalAddr())
+		MustNil(t, err)
+	}
+	for _, size := range sizes {
+		p, _, err := pconn.ReadPacket()
+		MustNil(t, err)
+		Equal(t, p.Len(), size)
+		p.Release()
+	}
+}
+
+func TestUDPConnectionFlushChunks(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer server.Close()
+
+	conn, err := DialConnection("udp", server.LocalAddr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	MustNil(t, conn.SetIdleTimeout(time.Minute))
+
+	// the chunks exceeding barriercap are still sent as a single datagram.
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2*barriercap; j++ {
+			var buf = NewLinkBuffer()
+			buf.WriteString("chunk")
+			buf.Flush()
+			_, err = conn.Writer().Append(buf)
+			MustNil(t, err)
+		}
+		MustNil(t, conn.Writer().Flush())
+	}
+	var recv = make([]byte, 64*1024)
+	for i := 0; i < 2; i++ {
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(recv)
+		MustNil(t, err)
+		Equal(t, n, 2*barriercap*len("chunk"))
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyProtocolMode defines how the PROXY protocol header is required.
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolStrict requires the header, the connections without it are closed.
+	ProxyProtocolStrict ProxyProtocolMode = iota
+	// ProxyProtocolOptional parses the header if present, otherwise the data is passed through.
+	ProxyProtocolOptional
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	crlf       = []byte("\r\n")
+)
+
+const (
+	// proxyV1MaxLen is the maximum length of v1 header, including CRLF.
+	proxyV1MaxLen = 107
+	// proxyV2HeaderLen is the length of the fixed part of v2 header.
+	proxyV2HeaderLen = 16
+)
+
+var errProxyHeaderMissing = errors.New("PROXY protocol header missing")
+
+// proxyProtocol parses the PROXY protocol header of the accepted connections before OnRequest.
+type proxyProtocol struct {
+	mode    ProxyProtocolMode
+	timeout time.Duration
+}
+
+// proxyState is the parsing state of a connection, 0(parsing) 1(parsed) 2(timeout).
+type proxyState struct {
+	state int32
+	timer atomic.Value // value is *time.Timer
+	addrs atomic.Value // value is proxyAddrs
+}
+
+// proxyAddrs is the addresses carried by the header, which replace the addresses of connection.
+type proxyAddrs struct {
+	src, dst net.Addr
+}
+
+// stop stops the timer of watch if it has been set.
+func (ps *proxyState) stop() {
+	if timer, ok := ps.timer.Load().(*time.Timer); ok {
+		timer.Stop()
+	}
+}
+
+// wrap makes the OnRequest of connection parse and strip the header first, which must be called in OnPrepare.
+// The connection is closed if the header is invalid.
+func (pp *proxyProtocol) wrap(conn Connection) {
+	var c, ok = conn.(*connection)
+	if !ok {
+		return
+	}
+	var process, _ = c.process.Load().(OnRequest)
+	if process == nil {
+		return
+	}
+	var ps = &proxyState{}
+	c.proxy = ps
+	var onRequest OnRequest = func(ctx context.Context, connection Connection) error {
+		if atomic.LoadInt32(&ps.state) == 0 {
+			src, dst, err := readProxyHeader(c, pp.mode)
+			if err != nil || !atomic.CompareAndSwapInt32(&ps.state, 0, 1) {
+				c.Close()
+				return err
+			}
+			ps.stop()
+			// the addresses may be read concurrently, such as by EventLoop.Connections.
+			if src != nil && dst != nil {
+				ps.addrs.Store(proxyAddrs{src: src, dst: dst})
+			}
+			c.process.Store(process)
+			if c.Reader().Len() == 0 {
+				return nil
+			}
+		}
+		return process(ctx, connection)
+	}
+	c.process.Store(onRequest)
+}
+
+// watch closes the connection if the header is not received within timeout,
+// which must be called after the connection has been registered.
+func (pp *proxyProtocol) watch(c *connection) {
+	var ps = c.proxy
+	if ps == nil || pp.timeout <= 0 {
+		return
+	}
+	var timer = time.AfterFunc(pp.timeout, func() {
+		if atomic.CompareAndSwapInt32(&ps.state, 0, 2) {
+			c.Close()
+		}
+	})
+	ps.timer.Store(timer)
+	// the header may have been parsed before the timer is stored.
+	if atomic.LoadInt32(&ps.state) != 0 {
+		timer.Stop()
+	}
+}
+
+// LocalAddr implements Connection, which is the destination address of PROXY protocol header if present.
+func (c *connection) LocalAddr() net.Addr {
+	if c.proxy != nil {
+		if addrs, ok := c.proxy.addrs.Load().(proxyAddrs); ok {
+			return addrs.dst
+		}
+	}
+	return c.netFD.LocalAddr()
+}
+
+// RemoteAddr implements Connection, which is the source address of PROXY protocol header if present.
+func (c *connection) RemoteAddr() net.Addr {
+	if c.proxy != nil {
+		if addrs, ok := c.proxy.addrs.Load().(proxyAddrs); ok {
+			return addrs.src
+		}
+	}
+	return c.netFD.RemoteAddr()
+}
+
+// readProxyHeader reads and strips the PROXY protocol header, and returns the source and destination addresses.
+// Nil addresses are returned if the header is absent in optional mode, or carries no addresses (v1 UNKNOWN, v2 LOCAL).
+func readProxyHeader(r Reader, mode ProxyProtocolMode) (src, dst net.Addr, err error) {
+	// wait for enough bytes to distinguish the signature
+	var p []byte
+	for need := 1; ; need = len(p) + 1 {
+		if l := r.Len(); l > need {
+			need = l
+		}
+		if need > len(proxyV2Sig) {
+			need = len(proxyV2Sig)
+		}
+		if p, err = r.Peek(need); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case bytes.HasPrefix(p, proxyV2Sig):
+			return readProxyV2(r)
+		case bytes.HasPrefix(p, proxyV1Sig):
+			return readProxyV1(r)
+		case !bytes.HasPrefix(proxyV2Sig, p) && !bytes.HasPrefix(proxyV1Sig, p):
+			if mode == ProxyProtocolStrict {
+				return nil, nil, errProxyHeaderMissing
+			}
+			return nil, nil, nil
+		}
+	}
+}
+
+// readProxyV1 reads the v1 text header, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func readProxyV1(r Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for need := len(proxyV1Sig) + 1; ; need++ {
+		if l := r.Len(); l > need {
+			need = l
+		}
+		if need > proxyV1MaxLen {
+			need = proxyV1MaxLen
+		}
+		p, err := r.Peek(need)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i := bytes.Index(p, crlf); i >= 0 {
+			line = p[:i]
+			break
+		}
+		if need == proxyV1MaxLen {
+			return nil, nil, errors.New("PROXY protocol v1 header too long")
+		}
+	}
+	var fields = strings.Split(string(line), " ")
+	if err = r.Skip(len(line) + len(crlf)); err != nil {
+		return nil, nil, err
+	}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.New("invalid PROXY protocol v1 header: " + string(line))
+	}
+	var srcIP, dstIP = net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, errors.New("invalid PROXY protocol v1 header: " + string(line))
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+// readProxyV2 reads the v2 binary header, the TLVs are skipped.
+func readProxyV2(r Reader) (src, dst net.Addr, err error) {
+	hdr, err := r.Next(proxyV2HeaderLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var verCmd, famProto = hdr[12], hdr[13]
+	var length = int(binary.BigEndian.Uint16(hdr[14:]))
+	if verCmd>>4 != 2 {
+		return nil, nil, errors.New("invalid PROXY protocol v2 version")
+	}
+	body, err := r.Next(length)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch verCmd & 0xF {
+	case 0x0: // LOCAL, e.g. health checks of the proxy
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, errors.New("invalid PROXY protocol v2 command")
+	}
+	var fam, proto = famProto >> 4, famProto & 0xF
+	var ipAddr = func(ip net.IP, port uint16) net.Addr {
+		if proto == 0x2 {
+			return &net.UDPAddr{IP: ip, Port: int(port)}
+		}
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	switch {
+	case fam == 0x1 && length >= 12: // AF_INET
+		src = ipAddr(net.IP(append([]byte{}, body[0:4]...)), binary.BigEndian.Uint16(body[8:]))
+		dst = ipAddr(net.IP(append([]byte{}, body[4:8]...)), binary.BigEndian.Uint16(body[10:]))
+	case fam == 0x2 && length >= 36: // AF_INET6
+		src = ipAddr(net.IP(append([]byte{}, body[0:16]...)), binary.BigEndian.Uint16(body[32:]))
+		dst = ipAddr(net.IP(append([]byte{}, body[16:32]...)), binary.BigEndian.Uint16(body[34:]))
+	case fam == 0x3 && length >= 216: // AF_UNIX
+		var network = "unix"
+		if proto == 0x2 {
+			network = "unixgram"
+		}
+		src = &net.UnixAddr{Name: unixPath(body[0:108]), Net: network}
+		dst = &net.UnixAddr{Name: unixPath(body[108:216]), Net: network}
+	case fam == 0x0: // AF_UNSPEC
+		return nil, nil, nil
+	default:
+		return nil, nil, errors.New("invalid PROXY protocol v2 address")
+	}
+	return src, dst, nil
+}
+
+// unixPath returns the path terminated by NUL.
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	var read = func(data []byte, mode ProxyProtocolMode) (src, dst net.Addr, rest string, err error) {
+		var buf = NewLinkBuffer()
+		buf.WriteBinary(data)
+		buf.Flush()
+		src, dst, err = readProxyHeader(buf, mode)
+		rest, _ = buf.ReadString(buf.Len())
+		return src, dst, rest, err
+	}
+
+	// v1
+	src, dst, rest, err := read([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping"), ProxyProtocolStrict)
+	MustNil(t, err)
+	Equal(t, src.String(), "192.168.0.1:56324")
+	Equal(t, dst.String(), "192.168.0.11:443")
+	Equal(t, rest, "ping")
+
+	src, dst, rest, err = read([]byte("PROXY TCP6 ::1 ::2 1 2\r\n"), ProxyProtocolStrict)
+	MustNil(t, err)
+	Equal(t, src.String(), "[::1]:1")
+	Equal(t, dst.String(), "[::2]:2")
+	Equal(t, rest, "")
+
+	src, dst, rest, err = read([]byte("PROXY UNKNOWN\r\nping"), ProxyProtocolStrict)
+	MustNil(t, err)
+	MustTrue(t, src == nil && dst == nil)
+	Equal(t, rest, "ping")
+
+	_, _, _, err = read([]byte("PROXY TCP4 x y 1 2\r\n"), ProxyProtocolStrict)
+	MustTrue(t, err != nil)
+
+	// v2
+	var v2 = func(cmd, fam byte, addrs []byte) []byte {
+		var b = append([]byte{}, proxyV2Sig...)
+		b = append(b, 0x20|cmd, fam, 0, 0)
+		binary.BigEndian.PutUint16(b[14:], uint16(len(addrs)))
+		return append(b, addrs...)
+	}
+	var ipv4 = []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x01, 0xBB}
+	src, dst, rest, err = read(append(v2(0x1, 0x11, ipv4), "ping"...), ProxyProtocolStrict)
+	MustNil(t, err)
+	Equal(t, src.String(), "10.0.0.1:8080")
+	Equal(t, dst.String(), "10.0.0.2:443")
+	Equal(t, src.Network(), "tcp")
+	Equal(t, rest, "ping")
+
+	// TLVs are skipped
+	src, _, rest, err = read(append(v2(0x1, 0x12, append(ipv4, 0x04, 0, 1, 0)), "ping"...), ProxyProtocolStrict)
+	MustNil(t, err)
+	Equal(t, src.Network(), "udp")
+	Equal(t, rest, "ping")
+
+	src, dst, rest, err = read(append(v2(0x0, 0x00, nil), "ping"...), ProxyProtocolStrict)
+	MustNil(t, err)
+	MustTrue(t, src == nil && dst == nil)
+	Equal(t, rest, "ping")
+
+	// absent
+	_, _, _, err = read([]byte("ping"), ProxyProtocolStrict)
+	Equal(t, err, errProxyHeaderMissing)
+	src, dst, rest, err = read([]byte("ping"), ProxyProtocolOptional)
+	MustNil(t, err)
+	MustTrue(t, src == nil && dst == nil)
+	Equal(t, rest, "ping")
+	// a prefix of signature is not enough to decide
+	_, _, _, err = read([]byte("PRO"), ProxyProtocolOptional)
+	MustTrue(t, err != nil)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	var network, address = "tcp", ":8897"
+	var addrs = make(chan [2]string, 1)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			s, err := connection.Reader().ReadString(4)
+			if err != nil {
+				return err
+			}
+			addrs <- [2]string{connection.RemoteAddr().String(), connection.LocalAddr().String()}
+			connection.Writer().WriteString(s)
+			return connection.Writer().Flush()
+		},
+		WithProxyProtocol(ProxyProtocolStrict, 100*time.Millisecond))
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	conn.Writer().WriteString("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n")
+	MustNil(t, conn.Writer().Flush())
+	conn.Writer().WriteString("ping")
+	MustNil(t, conn.Writer().Flush())
+	s, err := conn.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "ping")
+	Equal(t, <-addrs, [2]string{"192.168.0.1:56324", "192.168.0.11:443"})
+	var infos = loop.(Inspector).Connections()
+	Equal(t, len(infos), 1)
+	Equal(t, infos[0].RemoteAddr.String(), "192.168.0.1:56324")
+
+	// strict mode closes the connection without header
+	conn, err = DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	conn.Writer().WriteString("ping")
+	MustNil(t, conn.Writer().Flush())
+	_, err = conn.Reader().ReadString(4)
+	MustTrue(t, err != nil)
+
+	// header-read timeout
+	conn, err = DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	conn.Writer().WriteString("PROXY TCP4")
+	MustNil(t, conn.Writer().Flush())
+	var start = time.Now()
+	_, err = conn.Reader().ReadString(1)
+	MustTrue(t, err != nil)
+	MustTrue(t, time.Since(start) < time.Second)
+}
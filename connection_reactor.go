@@ -32,6 +32,9 @@ func (c *connection) onHup(p Poll) error {
 		if process, _ := c.process.Load().(OnRequest); process != nil {
 			c.closeCallback(true)
 		}
+		if onDisconnect, _ := c.disconnect.Load().(OnDisconnect); onDisconnect != nil {
+			onDisconnect(c)
+		}
 	}
 	return nil
 }
@@ -70,6 +73,11 @@ func (c *connection) inputs(vs [][]byte) (rs [][]byte) {
 	if !c.lock(reading) {
 		return rs
 	}
+	// datagram must be read in whole
+	if c.sotype == syscall.SOCK_DGRAM {
+		vs[0] = c.inputBuffer.bookPacket(maxPacketSize, packetNodeSize)
+		return vs[:1]
+	}
 	vs[0] = c.inputBuffer.book(c.bookSize, c.maxSize)
 	return vs[:1]
 }
@@ -91,7 +99,8 @@ func (c *connection) inputAck(n int) (err error) {
 	c.unlock(reading)
 
 	var needTrigger = true
-	if length == n {
+	// frames may be completed by the following data
+	if length == n || c.decoder != nil {
 		needTrigger = c.onRequest()
 	}
 	if needTrigger && length >= int(atomic.LoadInt32(&c.waitReadSize)) {
@@ -106,6 +115,11 @@ func (c *connection) outputs(vs [][]byte) (rs [][]byte, supportZeroCopy bool) {
 		c.rw2r()
 		return rs, c.supportZeroCopy
 	}
+	// datagram must be sent in whole by flush, here only wakes it up.
+	if c.sotype == syscall.SOCK_DGRAM {
+		c.rw2r()
+		return rs, c.supportZeroCopy
+	}
 	rs = c.outputBuffer.GetBytes(vs)
 	return rs, c.supportZeroCopy
 }
@@ -133,6 +147,9 @@ func (c *connection) flush() error {
 	if c.outputBuffer.IsEmpty() {
 		return nil
 	}
+	if c.sotype == syscall.SOCK_DGRAM {
+		return c.flushPacket()
+	}
 	// TODO: Let the upper layer pass in whether to use ZeroCopy.
 	var bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
 	var n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, false && c.supportZeroCopy)
@@ -158,3 +175,37 @@ func (c *connection) flush() error {
 	err = <-c.writeTrigger
 	return err
 }
+
+// flushPacket sends all the flushed data as a single datagram, which is dropped if failed,
+// so that it will not be merged with the data flushed later.
+func (c *connection) flushPacket() (err error) {
+	var size = c.outputBuffer.Len()
+	var bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
+	var n int
+	for i := range bs {
+		n += len(bs[i])
+	}
+	// too many chunks, copy into one.
+	if n < size {
+		bs = [][]byte{c.outputBuffer.Bytes()}
+	}
+	for {
+		_, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, false)
+		if err != syscall.EAGAIN {
+			break
+		}
+		// wait until writable, the poller will not send the datagram.
+		if err = c.operator.Control(PollR2RW); err != nil {
+			break
+		}
+		if err = <-c.writeTrigger; err != nil {
+			break
+		}
+	}
+	c.outputBuffer.Skip(size)
+	c.outputBuffer.Release()
+	if err != nil {
+		return Exception(err, "when flush")
+	}
+	return nil
+}
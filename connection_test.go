@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -112,6 +113,28 @@ func TestConnectionReadAfterClosed(t *testing.T) {
 	wg.Wait()
 }
 
+func TestConnectionOnDisconnect(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	var disconnected = make(chan struct{})
+	var closed int32
+	rconn.SetOnDisconnect(func(connection Connection) {
+		close(disconnected)
+	})
+	rconn.AddCloseCallback(func(connection Connection) error {
+		atomic.StoreInt32(&closed, 1)
+		return nil
+	})
+	syscall.Close(w)
+	<-disconnected
+	MustTrue(t, !rconn.IsActive())
+	// close callbacks are delayed until closing by user without OnRequest
+	Equal(t, atomic.LoadInt32(&closed), int32(0))
+	rconn.Close()
+	Equal(t, atomic.LoadInt32(&closed), int32(1))
+}
+
 func TestConnectionWaitReadHalfPacket(t *testing.T) {
 	r, w := GetSysFdPairs()
 	var rconn = &connection{}
@@ -281,3 +304,73 @@ func TestSetTCPNoDelay(t *testing.T) {
 	n, _ = syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
 	MustTrue(t, n == 0)
 }
+
+func TestConnectionWriteFDs(t *testing.T) {
+	var network, address = "unix", "fds.test.sock"
+	ln, err := CreateListener(network, address)
+	MustNil(t, err)
+	defer os.Remove(address)
+
+	var trigger = make(chan error, 1)
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		var conn = connection.(FDConnection)
+		s, err := conn.Reader().ReadString(4)
+		if err != nil || s != "pipe" {
+			trigger <- fmt.Errorf("read failed: %v %s", err, s)
+			return nil
+		}
+		// fds are available along with the data
+		var fds = conn.ReadFDs()
+		if len(fds) != 1 {
+			trigger <- fmt.Errorf("received %d fds", len(fds))
+			return nil
+		}
+		syscall.Write(fds[0], []byte("hello"))
+		syscall.Close(fds[0])
+		conn.Writer().WriteString("done")
+		trigger <- conn.Writer().Flush()
+		return nil
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	var fdconn = conn.(FDConnection)
+	if runtime.GOOS == "linux" {
+		MustNil(t, fdconn.SetPassCred(true))
+	}
+
+	var p [2]int
+	MustNil(t, syscall.Pipe(p[:]))
+	defer syscall.Close(p[0])
+	n, err := fdconn.WriteFDs([]int{p[1]}, []byte("pipe"))
+	MustNil(t, err)
+	Equal(t, n, 4)
+	// the caller still owns fds after sent
+	syscall.Close(p[1])
+	MustNil(t, <-trigger)
+
+	var buf = make([]byte, 8)
+	n, err = syscall.Read(p[0], buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "hello")
+
+	s, err := conn.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "done")
+	if runtime.GOOS == "linux" {
+		var cred = fdconn.ReadCredentials()
+		MustTrue(t, cred != nil)
+		Equal(t, int(cred.Pid), os.Getpid())
+		Equal(t, int(cred.Uid), os.Getuid())
+	}
+
+	// fds passing is only supported by unix stream socket
+	var tcp = &connection{operator: &FDOperator{}}
+	tcp.network = "tcp"
+	_, err = tcp.WriteFDs([]int{p[0]}, []byte("x"))
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+}
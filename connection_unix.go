@@ -0,0 +1,117 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+)
+
+// unixOOBSize is the size of ancillary data buffer, which can hold the maximum number of
+// file descriptors passed at once (SCM_MAX_FD = 253) and the credentials (struct ucred).
+var unixOOBSize = syscall.CmsgSpace(253*4) + syscall.CmsgSpace(3*4)
+
+var _ FDConnection = &connection{}
+
+// WriteFDs implements FDConnection.
+func (c *connection) WriteFDs(fds []int, data []byte) (n int, err error) {
+	if c.operator.OOB == nil {
+		return 0, Exception(ErrUnsupported, "WriteFDs on "+c.network)
+	}
+	if len(data) == 0 {
+		return 0, Exception(ErrUnsupported, "WriteFDs without data")
+	}
+	if !c.lock(flushing) {
+		return 0, Exception(ErrConnClosed, "when write fds")
+	}
+	defer c.unlock(flushing)
+	// send the flushed data first to keep the order.
+	if err = c.flush(); err != nil {
+		return 0, err
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	var bs = c.outputBarrier.bs[:1]
+	for n < len(data) {
+		bs[0] = data[n:]
+		var m int
+		m, err = sendmsgoob(c.fd, bs, c.outputBarrier.ivs, oob)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			if err == syscall.EAGAIN {
+				if err = c.operator.Control(PollR2RW); err != nil {
+					return n, Exception(err, "when write fds")
+				}
+				if err = <-c.writeTrigger; err != nil {
+					return n, Exception(err, "when write fds")
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return n, Exception(err, "when write fds")
+		}
+		n += m
+		// fds has been sent with the first byte.
+		oob = nil
+	}
+	return n, nil
+}
+
+// ReadFDs implements FDConnection.
+func (c *connection) ReadFDs() (fds []int) {
+	c.oobMux.Lock()
+	fds, c.rights = c.rights, nil
+	c.oobMux.Unlock()
+	return fds
+}
+
+// SetPassCred implements FDConnection.
+func (c *connection) SetPassCred(enable bool) error {
+	if c.operator.OOB == nil {
+		return Exception(ErrUnsupported, "SetPassCred on "+c.network)
+	}
+	return setPassCred(c.fd, enable)
+}
+
+// ReadCredentials implements FDConnection.
+func (c *connection) ReadCredentials() (cred *UnixCredentials) {
+	c.oobMux.Lock()
+	cred = c.credentials
+	c.oobMux.Unlock()
+	return cred
+}
+
+// inputOOB implements FDOperator.
+func (c *connection) inputOOB(oob []byte) (err error) {
+	var fds, cred = parseOOB(oob)
+	if len(fds) == 0 && cred == nil {
+		return nil
+	}
+	c.oobMux.Lock()
+	c.rights = append(c.rights, fds...)
+	if cred != nil {
+		c.credentials = cred
+	}
+	c.oobMux.Unlock()
+	return nil
+}
+
+// closeRights closes the file descriptors not taken by ReadFDs.
+func (c *connection) closeRights() {
+	for _, fd := range c.ReadFDs() {
+		syscall.Close(fd)
+	}
+}
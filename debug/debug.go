@@ -0,0 +1,129 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Package debug renders the live connections of netpoll.EventLoop over HTTP, so that on-call engineers
+ * can see which clients are holding memory. It's optional and should be served on an internal address:
+ *
+ *	http.Handle("/debug/netpoll", debug.Handler(eventLoop))
+ *
+ * Handler: render the connections sorted by buffered bytes, as a text table, or JSON with ?format=json.
+ */
+
+// Handler returns an http.Handler which renders the connections of loops, the ones buffering the most
+// bytes come first, and the number of rows is limited by ?limit=n.
+// The loops which do not implement netpoll.Inspector are skipped.
+func Handler(loops ...netpoll.EventLoop) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var infos []netpoll.ConnectionInfo
+		for _, loop := range loops {
+			if inspector, ok := loop.(netpoll.Inspector); ok {
+				infos = append(infos, inspector.Connections()...)
+			}
+		}
+		sort.SliceStable(infos, func(i, j int) bool {
+			return infos[i].InputLen+infos[i].OutputLen > infos[j].InputLen+infos[j].OutputLen
+		})
+		var summary = summarize(infos)
+		var limit int
+		if _, err := fmt.Sscan(r.FormValue("limit"), &limit); err == nil && limit >= 0 && limit < len(infos) {
+			infos = infos[:limit]
+		}
+
+		if r.FormValue("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			var rows = make([]row, len(infos))
+			for i := range infos {
+				rows[i] = newRow(&infos[i])
+			}
+			summary.Rows = rows
+			json.NewEncoder(w).Encode(summary)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "connections: %d, input: %d bytes, output: %d bytes\n\n",
+			summary.Connections, summary.InputLen, summary.OutputLen)
+		var tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "FD\tREMOTE\tLOCAL\tPOLLER\tINPUT\tOUTPUT\tPROCESSING\tAGE")
+		for i := range infos {
+			var info = &infos[i]
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%v\t%v\n", info.FD, addr(info.RemoteAddr), addr(info.LocalAddr),
+				info.Poller, info.InputLen, info.OutputLen, info.Processing, info.Age.Round(time.Second))
+		}
+		tw.Flush()
+	})
+}
+
+// summary is the JSON of all connections, whose rows may be limited.
+type summary struct {
+	Connections int   `json:"connections"`
+	InputLen    int   `json:"input"`
+	OutputLen   int   `json:"output"`
+	Rows        []row `json:"rows"`
+}
+
+func summarize(infos []netpoll.ConnectionInfo) summary {
+	var s = summary{Connections: len(infos)}
+	for i := range infos {
+		s.InputLen += infos[i].InputLen
+		s.OutputLen += infos[i].OutputLen
+	}
+	return s
+}
+
+// row is the JSON of netpoll.ConnectionInfo.
+type row struct {
+	FD         int     `json:"fd"`
+	RemoteAddr string  `json:"remote"`
+	LocalAddr  string  `json:"local"`
+	Poller     int     `json:"poller"`
+	InputLen   int     `json:"input"`
+	OutputLen  int     `json:"output"`
+	Processing bool    `json:"processing"`
+	Age        float64 `json:"age_seconds"`
+}
+
+func newRow(info *netpoll.ConnectionInfo) row {
+	return row{
+		FD:         info.FD,
+		RemoteAddr: addr(info.RemoteAddr),
+		LocalAddr:  addr(info.LocalAddr),
+		Poller:     info.Poller,
+		InputLen:   info.InputLen,
+		OutputLen:  info.OutputLen,
+		Processing: info.Processing,
+		Age:        info.Age.Seconds(),
+	}
+}
+
+func addr(a net.Addr) string {
+	if a == nil {
+		return "-"
+	}
+	return a.String()
+}
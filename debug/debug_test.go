@@ -0,0 +1,123 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var hold = make(chan struct{})
+	listener, err := netpoll.CreateListener("tcp", ":8924")
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		<-hold
+		_, err := connection.Reader().Next(connection.Reader().Len())
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(listener)
+	var idle, busy netpoll.Connection
+	// release the handler before shutdown, which waits for the busy connection.
+	defer func() {
+		close(hold)
+		loop.Shutdown(context.Background())
+		for _, conn := range []netpoll.Connection{idle, busy} {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	idle, err = netpoll.DialConnection("tcp", ":8924", time.Second)
+	MustNil(t, err)
+	busy, err = netpoll.DialConnection("tcp", ":8924", time.Second)
+	MustNil(t, err)
+	_, err = busy.Write([]byte("ping"))
+	MustNil(t, err)
+	var inspector = loop.(netpoll.Inspector)
+	var deadline = time.Now().Add(time.Second)
+	for infos := inspector.Connections(); len(infos) < 2 || infos[0].InputLen+infos[1].InputLen < 4; {
+		if time.Now().After(deadline) {
+			t.Fatal("the connections are not reported", infos)
+		}
+		runtime.Gosched()
+		infos = inspector.Connections()
+	}
+	var handler = Handler(loop)
+
+	// the busy one comes first
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/netpoll", nil))
+	var lines = strings.Split(w.Body.String(), "\n")
+	Equal(t, lines[0], "connections: 2, input: 4 bytes, output: 0 bytes")
+	MustTrue(t, strings.HasPrefix(lines[2], "FD"))
+	MustTrue(t, strings.Contains(lines[3], busy.LocalAddr().String()))
+	MustTrue(t, strings.Contains(lines[4], idle.LocalAddr().String()))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/netpoll?format=json&limit=1", nil))
+	Equal(t, w.Header().Get("Content-Type"), "application/json")
+	var s summary
+	MustNil(t, json.NewDecoder(w.Body).Decode(&s))
+	Equal(t, s.Connections, 2)
+	Equal(t, len(s.Rows), 1)
+	Equal(t, s.Rows[0].RemoteAddr, busy.LocalAddr().String())
+	Equal(t, s.Rows[0].InputLen, 4)
+	MustTrue(t, s.Rows[0].Processing)
+}
@@ -17,6 +17,7 @@ package netpoll
 import (
 	"runtime"
 	"sync/atomic"
+	"syscall"
 )
 
 // FDOperator is a collection of operations on file descriptors.
@@ -39,6 +40,12 @@ type FDOperator struct {
 	Outputs   func(vs [][]byte) (rs [][]byte, supportZeroCopy bool)
 	OutputAck func(n int) (err error)
 
+	// OOB is the optional buffer of ancillary data for connections.
+	// If it is not nil, the poll reads the ancillary data along with Inputs,
+	// and passes it to InputOOB before InputAck.
+	OOB      []byte
+	InputOOB func(oob []byte) (err error)
+
 	// poll is the registered location of the file descriptor.
 	poll Poll
 
@@ -51,6 +58,18 @@ func (op *FDOperator) Control(event PollEvent) error {
 	return op.poll.Control(op, event)
 }
 
+// readv reads data into bs, and also the ancillary data if OOB is set.
+func (op *FDOperator) readv(bs [][]byte, ivs []syscall.Iovec) (n int, err error) {
+	if op.OOB == nil {
+		return readv(op.FD, bs, ivs)
+	}
+	n, oobn, err := recvmsg(op.FD, bs, ivs, op.OOB)
+	if oobn > 0 {
+		op.InputOOB(op.OOB[:oobn])
+	}
+	return n, err
+}
+
 func (op *FDOperator) do() (can bool) {
 	return atomic.CompareAndSwapInt32(&op.state, 1, 2)
 }
@@ -86,5 +105,6 @@ func (op *FDOperator) reset() {
 	op.OnRead, op.OnRead, op.OnHup = nil, nil, nil
 	op.Inputs, op.InputAck = nil, nil
 	op.Outputs, op.OutputAck = nil, nil
+	op.OOB, op.InputOOB = nil, nil
 	op.poll = nil
 }
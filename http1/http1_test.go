@@ -0,0 +1,279 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http1
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func newTestReader(data string) netpoll.Reader {
+	var buf = netpoll.NewLinkBuffer()
+	buf.WriteString(data)
+	buf.Flush()
+	return buf
+}
+
+func newTestEventLoop(t *testing.T, address string, handler http.Handler, opts ...Option) {
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(handler, opts)
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		loop.Shutdown(ctx)
+	})
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestReadRequest(t *testing.T) {
+	// content-length body, and the next request pipelined
+	var r = newTestReader("\r\nPOST /echo?a=1 HTTP/1.1\r\nHost: example.com\r\nX-Key:  v1 \r\nx-key: v2\r\n" +
+		"Content-Length: 5\r\n\r\nhelloGET / HTTP/1.0\r\n\r\n")
+	req, err := ReadRequest(r, DefaultMaxHeaderBytes)
+	MustNil(t, err)
+	Equal(t, req.Method, "POST")
+	Equal(t, req.URL.Path, "/echo")
+	Equal(t, req.URL.RawQuery, "a=1")
+	Equal(t, req.Host, "example.com")
+	Equal(t, strings.Join(req.Header["X-Key"], ","), "v1,v2")
+	Equal(t, req.ContentLength, int64(5))
+	MustTrue(t, !req.Close)
+	data, err := ioutil.ReadAll(req.Body)
+	MustNil(t, err)
+	Equal(t, string(data), "hello")
+	req, err = ReadRequest(r, DefaultMaxHeaderBytes)
+	MustNil(t, err)
+	Equal(t, req.ProtoMinor, 0)
+	MustTrue(t, req.Close)
+	Equal(t, req.Body, http.NoBody)
+	Equal(t, r.Len(), 0)
+
+	// chunked body with extensions and trailers
+	r = newTestReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;ext=1\r\nhello\r\nA\r\n, world!!!\r\n0\r\nX-Trailer: t\r\n\r\nnext")
+	req, err = ReadRequest(r, DefaultMaxHeaderBytes)
+	MustNil(t, err)
+	Equal(t, req.ContentLength, int64(-1))
+	Equal(t, req.TransferEncoding[0], "chunked")
+	data, err = ioutil.ReadAll(req.Body)
+	MustNil(t, err)
+	Equal(t, string(data), "hello, world!!!")
+	Equal(t, r.Len(), len("next"))
+
+	// bad requests
+	for _, s := range []string{
+		"GET /\r\n\r\n",
+		"GET / HTTP/2.0\r\nHost: a\r\n\r\n",
+		"GET / HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\nBad Key: v\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\n folded\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 1\r\n\r\n",
+	} {
+		_, err = ReadRequest(newTestReader(s), DefaultMaxHeaderBytes)
+		_, ok := err.(*badRequestError)
+		Assert(t, ok, s, err)
+	}
+	_, err = ReadRequest(newTestReader("GET / HTTP/1.1\r\nHost: a\r\nX: "+strings.Repeat("a", 100)+"\r\n\r\n"), 64)
+	Equal(t, err.(*badRequestError).code, http.StatusRequestHeaderFieldsTooLarge)
+	req, err = ReadRequest(newTestReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nx\r\n"), DefaultMaxHeaderBytes)
+	MustNil(t, err)
+	_, err = ioutil.ReadAll(req.Body)
+	MustTrue(t, err != nil)
+}
+
+func TestServer(t *testing.T) {
+	var address = "127.0.0.1:8914"
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Remote", r.RemoteAddr)
+		w.Write(data)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "part%d;", i)
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	newTestEventLoop(t, address, mux)
+
+	var client = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1}}
+	defer client.CloseIdleConnections()
+	var remote string
+	for i := 0; i < 3; i++ {
+		var body = strings.Repeat("x", i*10000)
+		resp, err := client.Post("http://"+address+"/echo", "text/plain", strings.NewReader(body))
+		MustNil(t, err)
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		MustNil(t, err)
+		Equal(t, resp.StatusCode, http.StatusOK)
+		Equal(t, string(data), body)
+		Equal(t, resp.ContentLength, int64(len(body)))
+		// keep-alive
+		if i > 0 {
+			Equal(t, resp.Header.Get("X-Remote"), remote)
+		}
+		remote = resp.Header.Get("X-Remote")
+	}
+
+	resp, err := client.Get("http://" + address + "/stream")
+	MustNil(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	MustNil(t, err)
+	Equal(t, resp.TransferEncoding[0], "chunked")
+	Equal(t, string(data), "part0;part1;part2;")
+
+	resp, err = client.Get("http://" + address + "/missing")
+	MustNil(t, err)
+	resp.Body.Close()
+	Equal(t, resp.StatusCode, http.StatusNotFound)
+
+	_, err = client.Get("http://" + address + "/panic")
+	MustTrue(t, err != nil)
+}
+
+func TestPipelining(t *testing.T) {
+	var address = "127.0.0.1:8915"
+	newTestEventLoop(t, address, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	// the body of /a is not read by the handler, and /c closes the connection
+	_, err = conn.Write([]byte("POST /a HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /b HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"))
+	MustNil(t, err)
+	var br = bufio.NewReader(conn)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		resp, err := http.ReadResponse(br, nil)
+		MustNil(t, err)
+		data, err := ioutil.ReadAll(resp.Body)
+		MustNil(t, err)
+		Equal(t, string(data), path)
+		Equal(t, resp.Close, path == "/c")
+	}
+	_, err = br.ReadByte()
+	MustTrue(t, err != nil)
+
+	// malformed request
+	conn, err = net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	MustNil(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	MustNil(t, err)
+	Equal(t, resp.StatusCode, http.StatusBadRequest)
+	MustTrue(t, resp.Close)
+}
+
+func TestExpectContinue(t *testing.T) {
+	var address = "127.0.0.1:8916"
+	newTestEventLoop(t, address, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Write(data)
+	}))
+
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	MustNil(t, err)
+	var br = bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	MustNil(t, err)
+	Equal(t, resp.StatusCode, http.StatusContinue)
+	_, err = conn.Write([]byte("4\r\nping\r\n0\r\n\r\n"))
+	MustNil(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	MustNil(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	MustNil(t, err)
+	Equal(t, string(data), "ping")
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	var address = "127.0.0.1:8928"
+	newTestEventLoop(t, address, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), WithMaxHeaderBytes(64))
+
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX-Large: " + strings.Repeat("x", 64) + "\r\n\r\n"))
+	MustNil(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	MustNil(t, err)
+	resp.Body.Close()
+	Equal(t, resp.StatusCode, http.StatusRequestHeaderFieldsTooLarge)
+}
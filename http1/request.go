@@ -0,0 +1,359 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// maxLineBytes is the maximum size of the chunk size line and trailers.
+const maxLineBytes = 4096
+
+var (
+	crlf     = []byte("\r\n")
+	crlfcrlf = []byte("\r\n\r\n")
+
+	errBodyClosed  = errors.New("http1: invalid Read on closed Body")
+	errLineTooLong = errors.New("http1: line too long")
+)
+
+// badRequestError is the malformed request, which is responded with code.
+type badRequestError struct {
+	code   int
+	reason string
+}
+
+func (e *badRequestError) Error() string {
+	return "http1: " + e.reason
+}
+
+func badRequest(reason string) error {
+	return &badRequestError{code: http.StatusBadRequest, reason: reason}
+}
+
+// ReadRequest reads the request line and headers from r, which waits until they are received completely.
+// The body is read from r later by req.Body, which must be consumed before reading the next request.
+func ReadRequest(r netpoll.Reader, maxHeaderBytes int) (req *http.Request, err error) {
+	// ignore the empty lines before the request line
+	for {
+		p, err := r.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(p, crlf) {
+			break
+		}
+		r.Skip(2)
+	}
+	p, err := peekUntil(r, crlfcrlf, maxHeaderBytes)
+	if err == errLineTooLong {
+		return nil, &badRequestError{code: http.StatusRequestHeaderFieldsTooLarge, reason: "header too large"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// copy once, the strings below share the memory
+	var s = string(p[:len(p)-len(crlfcrlf)])
+	if err = r.Skip(len(p)); err != nil {
+		return nil, err
+	}
+
+	req = &http.Request{Header: make(http.Header)}
+	var line string
+	if i := strings.Index(s, "\r\n"); i >= 0 {
+		line, s = s[:i], s[i+2:]
+	} else {
+		line, s = s, ""
+	}
+	// request line
+	var ok bool
+	if req.Method, req.RequestURI, req.Proto, ok = parseRequestLine(line); !ok {
+		return nil, badRequest("malformed request line")
+	}
+	if req.ProtoMajor, req.ProtoMinor, ok = http.ParseHTTPVersion(req.Proto); !ok || req.ProtoMajor != 1 {
+		return nil, badRequest("unsupported protocol version")
+	}
+	if req.RequestURI == "*" && req.Method == http.MethodOptions {
+		req.URL = &url.URL{Path: "*"}
+	} else if req.URL, err = url.ParseRequestURI(req.RequestURI); err != nil {
+		return nil, badRequest("malformed request URI")
+	}
+	// headers
+	for len(s) > 0 {
+		if i := strings.Index(s, "\r\n"); i >= 0 {
+			line, s = s[:i], s[i+2:]
+		} else {
+			line, s = s, ""
+		}
+		i := strings.IndexByte(line, ':')
+		// obsolete line folding is rejected
+		if i <= 0 || strings.ContainsAny(line[:i], " \t") {
+			return nil, badRequest("malformed header")
+		}
+		key := textproto.CanonicalMIMEHeaderKey(line[:i])
+		req.Header[key] = append(req.Header[key], strings.Trim(line[i+1:], " \t"))
+	}
+
+	req.Host = req.URL.Host
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	if req.Host == "" && req.ProtoAtLeast(1, 1) {
+		return nil, badRequest("missing required Host header")
+	}
+	delete(req.Header, "Host")
+	req.Close = shouldClose(req)
+	if err = readBody(r, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseRequestLine parses "GET /index.html HTTP/1.1" into its three parts.
+func parseRequestLine(line string) (method, uri, proto string, ok bool) {
+	s1 := strings.IndexByte(line, ' ')
+	s2 := strings.IndexByte(line[s1+1:], ' ')
+	if s1 <= 0 || s2 <= 0 {
+		return "", "", "", false
+	}
+	s2 += s1 + 1
+	return line[:s1], line[s1+1 : s2], line[s2+1:], true
+}
+
+// shouldClose reports whether the connection should be closed after the request,
+// HTTP/1.1 keeps alive by default, while HTTP/1.0 requires "Connection: keep-alive".
+func shouldClose(req *http.Request) bool {
+	var conn = strings.Join(req.Header["Connection"], ",")
+	if req.ProtoAtLeast(1, 1) {
+		return hasToken(conn, "close")
+	}
+	return !hasToken(conn, "keep-alive")
+}
+
+// readBody sets the body of req, which is framed by chunked Transfer-Encoding or Content-Length.
+func readBody(r netpoll.Reader, req *http.Request) error {
+	var te, cl = req.Header["Transfer-Encoding"], req.Header["Content-Length"]
+	if len(te) > 0 {
+		// other encodings are not supported, and both headers may be used to smuggle requests
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") || len(cl) > 0 {
+			return &badRequestError{code: http.StatusNotImplemented, reason: "unsupported transfer encoding"}
+		}
+		delete(req.Header, "Transfer-Encoding")
+		req.TransferEncoding = []string{"chunked"}
+		req.ContentLength = -1
+		req.Body = &body{r: r, chunked: true}
+		return nil
+	}
+	if len(cl) > 0 {
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return badRequest("conflicting Content-Length")
+			}
+		}
+		n, err := strconv.ParseUint(cl[0], 10, 63)
+		if err != nil || n > uint64(^uint(0)>>1) {
+			return badRequest("invalid Content-Length")
+		}
+		req.ContentLength = int64(n)
+	}
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+		return nil
+	}
+	req.Body = &body{r: r, remain: int(req.ContentLength)}
+	return nil
+}
+
+// body reads the request body from the input buffer of connection.
+type body struct {
+	r       netpoll.Reader
+	remain  int // the size of body or current chunk not read
+	chunked bool
+	eof     bool
+	closed  bool
+	err     error
+	onRead  func() error // called before the first read, e.g. responding 100 Continue
+}
+
+// Read implements io.Reader.
+func (b *body) Read(p []byte) (n int, err error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	return b.read(p)
+}
+
+// Close implements io.Closer, the rest of body is discarded by the server.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) read(p []byte) (n int, err error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+	if b.onRead != nil {
+		var onRead = b.onRead
+		b.onRead = nil
+		if err = onRead(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+	if b.remain == 0 && b.chunked {
+		if b.err = b.nextChunk(); b.err != nil {
+			return 0, b.err
+		}
+		if b.eof {
+			return 0, io.EOF
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n = len(p)
+	if n > b.remain {
+		n = b.remain
+	}
+	// read the data received, or wait for at least one byte
+	if l := b.r.Len(); l < n {
+		if l == 0 {
+			l = 1
+		}
+		n = l
+	}
+	buf, err := b.r.Next(n)
+	if err != nil {
+		b.err = io.ErrUnexpectedEOF
+		return 0, b.err
+	}
+	copy(p, buf)
+	b.remain -= n
+	if b.remain == 0 {
+		if !b.chunked {
+			b.eof = true
+		} else if buf, err = b.r.Next(2); err != nil || !bytes.Equal(buf, crlf) {
+			b.err = badRequest("malformed chunked encoding")
+		}
+	}
+	return n, nil
+}
+
+// nextChunk reads the size line of next chunk, and the trailers after the last chunk, which are ignored.
+func (b *body) nextChunk() error {
+	p, err := readLine(b.r)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(p, ';'); i >= 0 {
+		p = p[:i]
+	}
+	p = bytes.TrimSpace(p)
+	size, err := strconv.ParseUint(string(p), 16, 63)
+	if err != nil || len(p) == 0 || size > uint64(^uint(0)>>1) {
+		return badRequest("malformed chunked encoding")
+	}
+	if size > 0 {
+		b.remain = int(size)
+		return nil
+	}
+	for {
+		if p, err = readLine(b.r); err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			b.eof = true
+			return nil
+		}
+	}
+}
+
+// drain discards the rest of body up to limit bytes, and reports whether the body has been read completely.
+func (b *body) drain(limit int) bool {
+	// the client is waiting for 100 Continue, so the body has not been sent
+	if b.onRead != nil {
+		return false
+	}
+	var buf [512]byte
+	for limit > 0 && !b.eof {
+		n, err := b.read(buf[:])
+		if err != nil && err != io.EOF {
+			return false
+		}
+		limit -= n
+	}
+	return b.eof
+}
+
+// readLine reads a line without CRLF, which is valid until the reader released.
+func readLine(r netpoll.Reader) (line []byte, err error) {
+	p, err := peekUntil(r, crlf, maxLineBytes)
+	if err != nil {
+		return nil, err
+	}
+	if line, err = r.Next(len(p)); err != nil {
+		return nil, err
+	}
+	return line[:len(line)-len(crlf)], nil
+}
+
+// peekUntil peeks r until delim, which waits for more data if not found, and fails if exceeding max bytes.
+func peekUntil(r netpoll.Reader, delim []byte, max int) (p []byte, err error) {
+	var from int
+	for need := len(delim); ; need = len(p) + 1 {
+		if l := r.Len(); l > need {
+			need = l
+		}
+		if need > max {
+			need = max
+		}
+		if p, err = r.Peek(need); err != nil {
+			return nil, err
+		}
+		if i := bytes.Index(p[from:], delim); i >= 0 {
+			return p[:from+i+len(delim)], nil
+		}
+		if need == max {
+			return nil, errLineTooLong
+		}
+		// the delim may be across the data peeked and the following
+		if from = need - len(delim) + 1; from < 0 {
+			from = 0
+		}
+	}
+}
+
+// hasToken reports whether the comma-separated header value contains token, case-insensitively.
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
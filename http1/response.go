@@ -0,0 +1,225 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// sniffLen is the maximum size of data used to detect the Content-Type.
+const sniffLen = 512
+
+// response implements http.ResponseWriter and http.Flusher.
+//
+// The body is buffered until the handler returns, so that Content-Length is set, unless Flush is called,
+// then the header is sent with chunked Transfer-Encoding (close-delimited for HTTP/1.0) if the length is unknown.
+type response struct {
+	conn          netpoll.Connection
+	req           *http.Request
+	header        http.Header
+	status        int
+	contentLength int // declared by handler, -1 means unknown
+	written       int // the size of body written
+	wroteHeader   bool
+	sent          bool // the header has been written to the connection
+	chunked       bool
+	closeAfter    bool
+	body          *netpoll.LinkBuffer // the body buffered before sending header
+}
+
+var _ http.ResponseWriter = &response{}
+var _ http.Flusher = &response{}
+
+func newResponse(conn netpoll.Connection, req *http.Request) *response {
+	return &response{
+		conn:          conn,
+		req:           req,
+		header:        make(http.Header),
+		contentLength: -1,
+		closeAfter:    req.Close,
+	}
+}
+
+// Header implements http.ResponseWriter.
+func (w *response) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("invalid WriteHeader code %v", code))
+	}
+	w.wroteHeader = true
+	w.status = code
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseUint(cl, 10, 63); err == nil && n <= uint64(^uint(0)>>1) {
+			w.contentLength = int(n)
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+// Write implements http.ResponseWriter, p is copied so it can be reused once returned.
+func (w *response) Write(p []byte) (n int, err error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.contentLength >= 0 && w.written+len(p) > w.contentLength {
+		return 0, http.ErrContentLength
+	}
+	w.written += len(p)
+	if w.req.Method == http.MethodHead {
+		return len(p), nil
+	}
+	if !w.sent {
+		if w.body == nil {
+			w.body = netpoll.NewLinkBuffer()
+		}
+		writer{w.body}.Write(p)
+		return len(p), w.body.Flush()
+	}
+	var out = w.conn.Writer()
+	if w.chunked {
+		fmt.Fprintf(writer{out}, "%x\r\n", len(p))
+		writer{out}.Write(p)
+		out.WriteString("\r\n")
+		return len(p), nil
+	}
+	return writer{out}.Write(p)
+}
+
+// Flush implements http.Flusher, which sends the header and the body written.
+func (w *response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.sent {
+		if w.contentLength < 0 && bodyAllowed(w.status) && w.req.Method != http.MethodHead {
+			if w.req.ProtoAtLeast(1, 1) {
+				w.chunked = true
+			} else {
+				w.closeAfter = true
+			}
+		}
+		w.sendHeader()
+	}
+	w.conn.Writer().Flush()
+}
+
+// finish completes the response after the handler returns.
+func (w *response) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.sent {
+		if w.contentLength < 0 && bodyAllowed(w.status) && (w.req.Method != http.MethodHead || w.written > 0) {
+			w.contentLength = w.written
+			w.header.Set("Content-Length", strconv.Itoa(w.written))
+		}
+		w.sendHeader()
+	}
+	if w.chunked {
+		w.conn.Writer().WriteString("0\r\n\r\n")
+	}
+	// the peer would wait for the rest of body
+	if w.contentLength >= 0 && w.written < w.contentLength && w.req.Method != http.MethodHead {
+		w.closeAfter = true
+	}
+	return w.conn.Writer().Flush()
+}
+
+// sendHeader writes the status line and header, followed by the body buffered.
+func (w *response) sendHeader() {
+	w.sent = true
+	var out = w.conn.Writer()
+	var h = w.header
+	h.Del("Transfer-Encoding")
+	if hasToken(h.Get("Connection"), "close") {
+		w.closeAfter = true
+	}
+	if w.closeAfter {
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+	if w.chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	if _, ok := h["Date"]; !ok {
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	// a nil value is set to suppress it
+	if _, ok := h["Content-Type"]; !ok && w.body != nil && w.body.Len() > 0 {
+		var n = w.body.Len()
+		if n > sniffLen {
+			n = sniffLen
+		}
+		data, _ := w.body.Peek(n)
+		h.Set("Content-Type", http.DetectContentType(data))
+	}
+	fmt.Fprintf(writer{out}, "HTTP/1.1 %03d %s\r\n", w.status, http.StatusText(w.status))
+	h.Write(writer{out})
+	out.WriteString("\r\n")
+
+	if w.body == nil || w.body.Len() == 0 {
+		return
+	}
+	if w.chunked {
+		fmt.Fprintf(writer{out}, "%x\r\n", w.body.Len())
+	}
+	out.Append(w.body)
+	if w.chunked {
+		out.WriteString("\r\n")
+	}
+	w.body = nil
+}
+
+// bodyAllowed reports whether a response with status is allowed to have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// writer adapts netpoll.Writer to io.Writer, which copies p into the memory allocated by Malloc.
+type writer struct {
+	w netpoll.Writer
+}
+
+func (w writer) Write(p []byte) (n int, err error) {
+	buf, err := w.w.Malloc(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf, p), nil
+}
+
+func (w writer) WriteString(s string) (n int, err error) {
+	return w.w.WriteString(s)
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http1
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Package http1 serves HTTP/1.x over netpoll, which parses requests directly from the nocopy Reader
+ * instead of running a goroutine per connection, and is suitable for simple endpoints like health and metrics.
+ *
+ * NewEventLoop: create an EventLoop serving http.Handler.
+ * OnRequest: adapt http.Handler to netpoll.OnRequest, which handles one request each call.
+ * ReadRequest: read a request from netpoll.Reader, which is useful to handle the upgrade requests.
+ *
+ * Keep-alive and pipelining are supported, the requests of a connection are handled in order.
+ * The request body is framed by Content-Length or chunked Transfer-Encoding, and the response is buffered
+ * with Content-Length set, unless the handler calls http.Flusher, then it's sent in chunks.
+ * Hijacking and protocol upgrades are not supported.
+ */
+
+// DefaultMaxHeaderBytes is the default maximum size of the request line and headers.
+const DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+// maxDrainBytes is the maximum size of the request body discarded after handling to reuse the connection.
+const maxDrainBytes = 256 << 10
+
+// Option .
+type Option struct {
+	f func(*options)
+}
+
+type options struct {
+	maxHeaderBytes int
+}
+
+// WithMaxHeaderBytes sets the maximum size of the request line and headers, exceeding it responds 431.
+func WithMaxHeaderBytes(n int) Option {
+	return Option{func(op *options) {
+		op.maxHeaderBytes = n
+	}}
+}
+
+// NewEventLoop creates an EventLoop serving handler, opts configures the server as OnRequest,
+// and ops configures the EventLoop.
+func NewEventLoop(handler http.Handler, opts []Option, ops ...netpoll.Option) (netpoll.EventLoop, error) {
+	return netpoll.NewEventLoop(OnRequest(handler, opts...), ops...)
+}
+
+// OnRequest returns netpoll.OnRequest which reads one request from the connection, and writes the response
+// of handler. The connection is closed if the request is malformed, or it's not going to be kept alive.
+func OnRequest(handler http.Handler, opts ...Option) netpoll.OnRequest {
+	var s = &server{handler: handler}
+	s.opts.maxHeaderBytes = DefaultMaxHeaderBytes
+	for _, do := range opts {
+		do.f(&s.opts)
+	}
+	return s.serve
+}
+
+type server struct {
+	opts    options
+	handler http.Handler
+}
+
+// serve handles one request of the connection.
+func (s *server) serve(ctx context.Context, conn netpoll.Connection) (err error) {
+	var reader = conn.Reader()
+	defer reader.Release()
+	req, err := ReadRequest(reader, s.opts.maxHeaderBytes)
+	if err != nil {
+		if e, ok := err.(*badRequestError); ok {
+			writeError(conn.Writer(), e)
+		}
+		conn.Close()
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	var w = newResponse(conn, req)
+	if b, ok := req.Body.(*body); ok && hasToken(req.Header.Get("Expect"), "100-continue") {
+		b.onRead = func() error {
+			conn.Writer().WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+			return conn.Writer().Flush()
+		}
+	}
+	if !s.handle(w, req) {
+		return conn.Close()
+	}
+	// discard the body not read, to reach the next request
+	if b, ok := req.Body.(*body); ok && !b.drain(maxDrainBytes) {
+		w.closeAfter = true
+	}
+	if err = w.finish(); err != nil || w.closeAfter {
+		conn.Close()
+	}
+	return err
+}
+
+// handle calls the handler, and returns false if it panics.
+func (s *server) handle(w *response, req *http.Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Printf("http1: panic serving %s: %v\n%s", req.RemoteAddr, err, buf)
+		}
+	}()
+	s.handler.ServeHTTP(w, req)
+	return true
+}
+
+// writeError responds the error and closes the connection.
+func writeError(w netpoll.Writer, e *badRequestError) {
+	var text = fmt.Sprintf("%d %s", e.code, http.StatusText(e.code))
+	fmt.Fprintf(writer{w}, "HTTP/1.1 %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		text, len(text), text)
+	w.Flush()
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Client multiplexes concurrent calls over one netpoll.Connection.
+ * Each request is framed with a header carrying its sequence ID, merged and sent by ShardQueue,
+ * and the response with the same sequence ID is routed back to the caller by OnRequest.
+ *
+ * NewClient: create a client with netpoll.Connection, which takes over the OnRequest of the connection.
+ * Client.Call: send a request and wait for its response, until timeout or ctx is done.
+ * FrameHeader: the pluggable frame header, DefaultFrameHeader by default.
+ */
+
+// FrameHeader encodes and decodes the fixed-size header of frames, which carries the sequence ID
+// and the size of body following it.
+type FrameHeader interface {
+	// Size returns the size of header.
+	Size() int
+	// Encode writes the header of seqID and body size into buf, whose length is Size.
+	Encode(buf []byte, seqID uint32, bodySize int)
+	// Decode parses the header in buf, whose length is Size, and returns an error if it's invalid.
+	Decode(buf []byte) (seqID uint32, bodySize int, err error)
+}
+
+// DefaultFrameHeader is the 8 bytes header, which is composed of the body size
+// and the sequence ID in big endian uint32.
+var DefaultFrameHeader FrameHeader = defaultFrameHeader{}
+
+type defaultFrameHeader struct{}
+
+func (defaultFrameHeader) Size() int {
+	return 8
+}
+
+func (defaultFrameHeader) Encode(buf []byte, seqID uint32, bodySize int) {
+	binary.BigEndian.PutUint32(buf[0:4], uint32(bodySize))
+	binary.BigEndian.PutUint32(buf[4:8], seqID)
+}
+
+func (defaultFrameHeader) Decode(buf []byte) (seqID uint32, bodySize int, err error) {
+	return binary.BigEndian.Uint32(buf[4:8]), int(binary.BigEndian.Uint32(buf[0:4])), nil
+}
+
+// ClientOption .
+type ClientOption struct {
+	f func(*clientOptions)
+}
+
+type clientOptions struct {
+	header  FrameHeader
+	timeout time.Duration
+}
+
+// WithFrameHeader sets the FrameHeader of requests and responses.
+func WithFrameHeader(header FrameHeader) ClientOption {
+	return ClientOption{func(op *clientOptions) {
+		op.header = header
+	}}
+}
+
+// WithCallTimeout sets the default timeout of each Call, 0 means no timeout except the deadline of ctx.
+func WithCallTimeout(timeout time.Duration) ClientOption {
+	return ClientOption{func(op *clientOptions) {
+		op.timeout = timeout
+	}}
+}
+
+// NewClient creates a Client with conn, which must be used by the Client only.
+// The OnRequest of conn is replaced to receive responses, so it must be called before sending any data.
+func NewClient(conn netpoll.Connection, opts ...ClientOption) *Client {
+	c := &Client{
+		conn:    conn,
+		queue:   NewShardQueue(ShardSize, conn),
+		pending: make(map[uint32]chan *result),
+	}
+	c.opts.header = DefaultFrameHeader
+	for _, do := range opts {
+		do.f(&c.opts)
+	}
+	conn.SetOnRequest(c.onRequest)
+	conn.AddCloseCallback(func(connection netpoll.Connection) error {
+		c.fail(netpoll.Exception(netpoll.ErrConnClosed, "mux client"))
+		return nil
+	})
+	return c
+}
+
+// Client multiplexes concurrent calls over one Connection.
+type Client struct {
+	opts  clientOptions
+	conn  netpoll.Connection
+	queue *ShardQueue
+	seqID uint32
+
+	mu      sync.Mutex
+	pending map[uint32]chan *result // key=seqID
+	closed  error
+}
+
+type result struct {
+	body netpoll.Reader
+	err  error
+}
+
+// Call sends the request body and waits for its response body, which is a zero-copy slice of the input buffer
+// and must be released by the caller. It returns ErrConnClosed if the connection is closed before responding,
+// or the error of ctx if ctx is done or timeout.
+func (c *Client) Call(ctx context.Context, body []byte) (resp netpoll.Reader, err error) {
+	if c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	seqID := atomic.AddUint32(&c.seqID, 1)
+	ch := make(chan *result, 1)
+	c.mu.Lock()
+	if c.closed != nil {
+		c.mu.Unlock()
+		return nil, c.closed
+	}
+	c.pending[seqID] = ch
+	c.mu.Unlock()
+
+	if err = c.queue.Add(c.frame(seqID, body)); err != nil {
+		c.mu.Lock()
+		delete(c.pending, seqID)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res.body, res.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, seqID)
+		c.mu.Unlock()
+		// the response may arrive before deleting
+		select {
+		case res := <-ch:
+			if res.body != nil {
+				res.body.Release()
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the connection, and fails all the calls in flight with ErrConnClosed.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// frame returns the WriterGetter of the frame to be sent.
+func (c *Client) frame(seqID uint32, body []byte) WriterGetter {
+	return func() (netpoll.Writer, bool) {
+		buf := netpoll.NewLinkBuffer()
+		header, _ := buf.Malloc(c.opts.header.Size())
+		c.opts.header.Encode(header, seqID, len(body))
+		buf.WriteBinary(body)
+		buf.Flush()
+		return buf, false
+	}
+}
+
+// onRequest decodes the responses and routes them to the callers.
+func (c *Client) onRequest(ctx context.Context, connection netpoll.Connection) error {
+	reader := connection.Reader()
+	header, err := reader.Next(c.opts.header.Size())
+	if err != nil {
+		return connection.Close()
+	}
+	seqID, size, err := c.opts.header.Decode(header)
+	if err != nil || size < 0 {
+		return connection.Close()
+	}
+	body, err := reader.Slice(size)
+	if err != nil {
+		return connection.Close()
+	}
+
+	c.mu.Lock()
+	ch := c.pending[seqID]
+	delete(c.pending, seqID)
+	c.mu.Unlock()
+	// the caller has gone
+	if ch == nil {
+		return body.Release()
+	}
+	ch <- &result{body: body}
+	return nil
+}
+
+// fail fails all the calls in flight with err.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != nil {
+		return
+	}
+	c.closed = err
+	for seqID, ch := range c.pending {
+		ch <- &result{err: err}
+		delete(c.pending, seqID)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// shortFrameHeader is composed of the body size in uint16 and the sequence ID in uint32.
+type shortFrameHeader struct{}
+
+func (shortFrameHeader) Size() int {
+	return 6
+}
+
+func (shortFrameHeader) Encode(buf []byte, seqID uint32, bodySize int) {
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(bodySize))
+	binary.LittleEndian.PutUint32(buf[2:6], seqID)
+}
+
+func (shortFrameHeader) Decode(buf []byte) (seqID uint32, bodySize int, err error) {
+	return binary.LittleEndian.Uint32(buf[2:6]), int(binary.LittleEndian.Uint16(buf[0:2])), nil
+}
+
+// newTestServer serves the frames of header, and echoes the body in a new goroutine, so that the responses
+// are out of order. The body "hang" is never responded, and "slow" is responded after 100ms.
+func newTestServer(t *testing.T, address string, header FrameHeader) {
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(nil, netpoll.WithOnPrepare(func(connection netpoll.Connection) context.Context {
+		var mu sync.Mutex
+		connection.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+			reader := connection.Reader()
+			buf, err := reader.Next(header.Size())
+			if err != nil {
+				return err
+			}
+			seqID, size, _ := header.Decode(buf)
+			body, err := reader.ReadString(size)
+			if err != nil {
+				return err
+			}
+			go func() {
+				switch body {
+				case "hang":
+					return
+				case "slow":
+					time.Sleep(100 * time.Millisecond)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				writer := connection.Writer()
+				buf, _ := writer.Malloc(header.Size())
+				header.Encode(buf, seqID, len(body))
+				writer.WriteString(body)
+				writer.Flush()
+			}()
+			return nil
+		})
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		loop.Shutdown(ctx)
+	})
+}
+
+func TestClientCall(t *testing.T) {
+	for i, header := range []FrameHeader{DefaultFrameHeader, shortFrameHeader{}} {
+		var address = fmt.Sprintf("127.0.0.1:%d", 8908+i)
+		newTestServer(t, address, header)
+		conn, err := netpoll.DialConnection("tcp", address, time.Second)
+		MustNil(t, err)
+		client := NewClient(conn, WithFrameHeader(header))
+
+		var wg sync.WaitGroup
+		for j := 0; j < 100; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				var req = fmt.Sprintf("request-%d", j)
+				resp, err := client.Call(context.Background(), []byte(req))
+				MustNil(t, err)
+				defer resp.Release()
+				got, err := resp.ReadString(resp.Len())
+				MustNil(t, err)
+				Equal(t, got, req)
+			}(j)
+		}
+		wg.Wait()
+		MustNil(t, client.Close())
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var address = "127.0.0.1:8910"
+	newTestServer(t, address, DefaultFrameHeader)
+	conn, err := netpoll.DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	client := NewClient(conn, WithCallTimeout(50*time.Millisecond))
+	defer client.Close()
+
+	_, err = client.Call(context.Background(), []byte("slow"))
+	Equal(t, err, context.DeadlineExceeded)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = client.Call(ctx, []byte("hang"))
+	Equal(t, err, context.Canceled)
+
+	// the late response is dropped, and the following calls still work
+	time.Sleep(100 * time.Millisecond)
+	resp, err := client.Call(context.Background(), []byte("fast"))
+	MustNil(t, err)
+	got, _ := resp.ReadString(resp.Len())
+	Equal(t, got, "fast")
+	resp.Release()
+}
+
+func TestClientClose(t *testing.T) {
+	var address = "127.0.0.1:8911"
+	newTestServer(t, address, DefaultFrameHeader)
+	conn, err := netpoll.DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	client := NewClient(conn)
+
+	var errs = make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := client.Call(context.Background(), []byte("hang"))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	MustNil(t, client.Close())
+	for i := 0; i < cap(errs); i++ {
+		MustTrue(t, errors.Is(<-errs, netpoll.ErrConnClosed))
+	}
+	_, err = client.Call(context.Background(), []byte("fast"))
+	MustTrue(t, errors.Is(err, netpoll.ErrConnClosed))
+}
@@ -15,8 +15,11 @@
 package mux
 
 import (
+	"errors"
 	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cloudwego/netpoll"
 )
@@ -24,16 +27,74 @@ import (
 /* DOC:
  * ShardQueue uses the netpoll's nocopy API to merge and send data.
  * The Data Flush is passively triggered by ShardQueue.Add and does not require user operations.
- * If there is an error in the data transmission, the connection will be closed.
+ * If there is an error in the data transmission, the connection will be closed,
+ * and the getters not sent are reported to the ErrorHandler.
  *
- * ShardQueue.Add: add the data to be sent.
+ * ShardQueue.Add: add the data to be sent, which blocks or fails when the queue is full if limited.
+ * ShardQueue.Close: stop adding, and wait for the queued data to be flushed.
  * NewShardQueue: create a queue with netpoll.Connection.
  * ShardSize: the recommended number of shards is 32.
  */
 const ShardSize = 32
 
+// ErrQueueClosed is returned by ShardQueue.Add when the queue has been closed.
+var ErrQueueClosed = errors.New("shard queue has been closed")
+
+// ErrQueueFull is returned by ShardQueue.Add when the queued getters exceed the limit in nonblocking mode.
+var ErrQueueFull = errors.New("shard queue is full")
+
+// ErrorHandler is called once when the data transmission fails, with the error and the getters which are
+// never called, so not sent. The data of getters called before the failure may or may not have been sent.
+type ErrorHandler func(err error, unsent []WriterGetter)
+
+// QueueOption .
+type QueueOption struct {
+	f func(*queueOptions)
+}
+
+type queueOptions struct {
+	onError       ErrorHandler
+	maxQueued     int
+	maxBytes      int
+	block         bool
+	maxBatchBytes int
+	maxDelay      time.Duration
+}
+
+// WithErrorHandler sets the ErrorHandler of ShardQueue.
+func WithErrorHandler(onError ErrorHandler) QueueOption {
+	return QueueOption{func(op *queueOptions) {
+		op.onError = onError
+	}}
+}
+
+// WithMaxQueued limits the number of getters and the bytes of their data queued but not dealt yet, 0 means
+// no limit. When it's exceeded, Add blocks until there is room if block is true, otherwise fails with ErrQueueFull.
+//
+// PLEASE NOTE:
+// To know the size of data, the getters are called in Add instead of when dealt if maxBytes is set,
+// so the data must be ready when adding.
+func WithMaxQueued(maxItems, maxBytes int, block bool) QueueOption {
+	return QueueOption{func(op *queueOptions) {
+		op.maxQueued = maxItems
+		op.maxBytes = maxBytes
+		op.block = block
+	}}
+}
+
+// WithFlushPolicy flushes the merged data once it reaches maxBatchBytes, or maxDelay has passed since the
+// first data merged, rather than only after sweeping all the shards. So that a large burst is sent by several
+// batches instead of one huge output buffer. 0 means no limit.
+// The delay is measured by a timer, which flushes even if a getter is being called.
+func WithFlushPolicy(maxBatchBytes int, maxDelay time.Duration) QueueOption {
+	return QueueOption{func(op *queueOptions) {
+		op.maxBatchBytes = maxBatchBytes
+		op.maxDelay = maxDelay
+	}}
+}
+
 // NewShardQueue .
-func NewShardQueue(size int32, conn netpoll.Connection) (queue *ShardQueue) {
+func NewShardQueue(size int32, conn netpoll.Connection, opts ...QueueOption) (queue *ShardQueue) {
 	queue = &ShardQueue{
 		conn:    conn,
 		size:    size,
@@ -44,6 +105,10 @@ func NewShardQueue(size int32, conn netpoll.Connection) (queue *ShardQueue) {
 	for i := range queue.getters {
 		queue.getters[i] = make([]WriterGetter, 0, 64)
 	}
+	for _, do := range opts {
+		do.f(&queue.opts)
+	}
+	queue.space = sync.NewCond(&queue.mu)
 	return queue
 }
 
@@ -55,24 +120,154 @@ type WriterGetter func() (buf netpoll.Writer, isNil bool)
 // If there is an error in the data transmission, the connection will be closed.
 // ShardQueue.Add: add the data to be sent.
 type ShardQueue struct {
+	opts            queueOptions
 	conn            netpoll.Connection
 	idx, size       int32
 	getters         [][]WriterGetter // len(getters) = size
 	swap            []WriterGetter   // use for swap
 	locks           []int32          // len(locks) = size
 	trigger, runNum int32
+	closed          int32
+	err             atomic.Value   // value is error
+	wg              sync.WaitGroup // the adding ones and the running foreach, waited by Close
+
+	// the number and bytes of queued getters, only counted when limited
+	mu          sync.Mutex
+	space       *sync.Cond
+	queued      int
+	queuedBytes int
+
+	// the merged data not flushed, which is written by foreach and flushed by foreach or the delay timer
+	wmu       sync.Mutex
+	batchSize int
+	timer     *time.Timer
+	timerErr  error // the error of flushing by timer, which is reported by foreach
 }
 
-// Add adds to q.getters[shard]
-func (q *ShardQueue) Add(gts ...WriterGetter) {
+// Add adds to q.getters[shard]. It returns ErrQueueClosed after Close, ErrConnClosed if the connection
+// has been closed, or the error of data transmission once it fails, and gts are not sent.
+func (q *ShardQueue) Add(gts ...WriterGetter) error {
+	var bytes int
+	if q.opts.maxBytes > 0 {
+		gts, bytes = prefetch(gts)
+	}
+	if err := q.reserve(len(gts), bytes); err != nil {
+		return err
+	}
 	shard := atomic.AddInt32(&q.idx, 1) % q.size
 	q.lock(shard)
+	if atomic.LoadInt32(&q.closed) != 0 {
+		q.unlock(shard)
+		q.release(len(gts), bytes)
+		return q.closedErr()
+	}
+	if !q.conn.IsActive() {
+		q.unlock(shard)
+		q.release(len(gts), bytes)
+		return netpoll.Exception(netpoll.ErrConnClosed, "when add to shard queue")
+	}
+	q.wg.Add(1)
+	defer q.wg.Done()
 	trigger := len(q.getters[shard]) == 0
 	q.getters[shard] = append(q.getters[shard], gts...)
 	q.unlock(shard)
 	if trigger {
 		q.triggering(shard)
 	}
+	return nil
+}
+
+// Close stops adding to the queue, and waits for the queued data to be flushed.
+// It returns the error of data transmission if failed.
+func (q *ShardQueue) Close() error {
+	atomic.StoreInt32(&q.closed, 1)
+	q.wakeup()
+	// the adding ones have been counted by wg after the shards are unlocked.
+	for shard := int32(0); shard < q.size; shard++ {
+		q.lock(shard)
+		q.unlock(shard)
+	}
+	q.wg.Wait()
+	if err, _ := q.err.Load().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+// prefetch calls the getters to get the size of data, and returns the getters of the data got.
+func prefetch(gts []WriterGetter) (fetched []WriterGetter, bytes int) {
+	fetched = make([]WriterGetter, len(gts))
+	for i, gt := range gts {
+		buf, isNil := gt()
+		if !isNil {
+			bytes += size(buf)
+		}
+		fetched[i] = func() (netpoll.Writer, bool) {
+			return buf, isNil
+		}
+	}
+	return fetched, bytes
+}
+
+// reserve takes room for n getters of the data in bytes, and waits for room if blocking.
+// It's allowed if the queue is empty, even if it's larger than the limit.
+func (q *ShardQueue) reserve(n, bytes int) error {
+	if q.opts.maxQueued <= 0 && q.opts.maxBytes <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.full(n, bytes) {
+		if atomic.LoadInt32(&q.closed) != 0 {
+			return q.closedErr()
+		}
+		if !q.opts.block {
+			return ErrQueueFull
+		}
+		q.space.Wait()
+	}
+	if atomic.LoadInt32(&q.closed) != 0 {
+		return q.closedErr()
+	}
+	q.queued += n
+	q.queuedBytes += bytes
+	return nil
+}
+
+// full reports whether there is no room for n getters of the data in bytes, the lock must be held.
+func (q *ShardQueue) full(n, bytes int) bool {
+	if q.opts.maxQueued > 0 && q.queued > 0 && q.queued+n > q.opts.maxQueued {
+		return true
+	}
+	return q.opts.maxBytes > 0 && q.queuedBytes > 0 && q.queuedBytes+bytes > q.opts.maxBytes
+}
+
+// release gives back the room of n getters and the data in bytes.
+// The getters are released when swapped out, and the bytes are released when dealt.
+func (q *ShardQueue) release(n, bytes int) {
+	if q.opts.maxQueued <= 0 && q.opts.maxBytes <= 0 || n == 0 && bytes == 0 {
+		return
+	}
+	q.mu.Lock()
+	q.queued -= n
+	q.queuedBytes -= bytes
+	q.space.Broadcast()
+	q.mu.Unlock()
+}
+
+// wakeup wakes up the blocked Add after closing.
+func (q *ShardQueue) wakeup() {
+	q.mu.Lock()
+	q.space.Broadcast()
+	q.mu.Unlock()
+}
+
+// closedErr returns the error of data transmission first.
+func (q *ShardQueue) closedErr() error {
+	if err, _ := q.err.Load().(error); err != nil {
+		return err
+	}
+	return ErrQueueClosed
 }
 
 // triggering shard.
@@ -88,7 +283,9 @@ func (q *ShardQueue) foreach(shard int32) {
 	if atomic.AddInt32(&q.runNum, 1) > 1 {
 		return
 	}
+	q.wg.Add(1)
 	go func() {
+		defer q.wg.Done()
 		var tmp []WriterGetter
 		for ; atomic.LoadInt32(&q.trigger) > 0; shard = (shard + 1) % q.size {
 			// lock & swap
@@ -103,6 +300,7 @@ func (q *ShardQueue) foreach(shard int32) {
 			q.swap = tmp
 			q.unlock(shard)
 			atomic.AddInt32(&q.trigger, -1)
+			q.release(len(q.swap), 0)
 
 			// deal
 			q.deal(q.swap)
@@ -120,27 +318,114 @@ func (q *ShardQueue) foreach(shard int32) {
 // deal is used to get deal of netpoll.Writer.
 func (q *ShardQueue) deal(gts []WriterGetter) {
 	writer := q.conn.Writer()
-	for _, gt := range gts {
+	for i, gt := range gts {
 		buf, isNil := gt()
-		if !isNil {
-			_, err := writer.Append(buf)
-			if err != nil {
-				q.conn.Close()
-				return
-			}
+		if isNil {
+			continue
+		}
+		n := size(buf)
+		if q.opts.maxBytes > 0 {
+			q.release(0, n)
+		}
+		q.wmu.Lock()
+		// flushing by timer failed, so the data is not appended
+		if err := q.timerErr; err != nil {
+			q.wmu.Unlock()
+			q.fail(err, gts[i:])
+			return
+		}
+		_, err := writer.Append(buf)
+		if err == nil && q.batch(n) {
+			err = q.send()
+		}
+		q.wmu.Unlock()
+		if err != nil {
+			q.fail(err, gts[i+1:])
+			return
+		}
+	}
+}
+
+// size returns the size of data in w, including the part flushed into LinkBuffer but not read.
+func size(w netpoll.Writer) int {
+	if r, ok := w.(interface{ Len() int }); ok {
+		return r.Len() + w.MallocLen()
+	}
+	return w.MallocLen()
+}
+
+// batch adds n bytes to the merged data, and reports whether it should be flushed now.
+// The delay timer is armed by the first data of the batch. The wmu must be held.
+func (q *ShardQueue) batch(n int) bool {
+	if q.batchSize == 0 && q.opts.maxDelay > 0 {
+		if q.timer == nil {
+			q.timer = time.AfterFunc(q.opts.maxDelay, q.onDelay)
+		} else {
+			q.timer.Reset(q.opts.maxDelay)
 		}
 	}
+	q.batchSize += n
+	return q.opts.maxBatchBytes > 0 && q.batchSize >= q.opts.maxBatchBytes
+}
+
+// onDelay flushes the merged data once maxDelay has passed, and the error is reported by foreach.
+func (q *ShardQueue) onDelay() {
+	q.wmu.Lock()
+	defer q.wmu.Unlock()
+	if q.batchSize == 0 || q.timerErr != nil || q.err.Load() != nil {
+		return
+	}
+	q.timerErr = q.send()
 }
 
 // flush is used to flush netpoll.Writer.
 func (q *ShardQueue) flush() {
-	err := q.conn.Writer().Flush()
+	if q.err.Load() != nil {
+		return
+	}
+	q.wmu.Lock()
+	err := q.timerErr
+	if err == nil {
+		err = q.send()
+	}
+	q.wmu.Unlock()
 	if err != nil {
-		q.conn.Close()
+		q.fail(err, nil)
 		return
 	}
 }
 
+// send flushes the merged data, the wmu must be held.
+func (q *ShardQueue) send() error {
+	q.batchSize = 0
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	return q.conn.Writer().Flush()
+}
+
+// fail closes the queue and the connection, then reports err with the getters not sent, including the queued ones.
+func (q *ShardQueue) fail(err error, unsent []WriterGetter) {
+	q.err.Store(err)
+	atomic.StoreInt32(&q.closed, 1)
+	q.conn.Close()
+	var gts = append([]WriterGetter{}, unsent...)
+	for shard := int32(0); shard < q.size; shard++ {
+		q.lock(shard)
+		if n := len(q.getters[shard]); n > 0 {
+			gts = append(gts, q.getters[shard]...)
+			q.getters[shard] = q.getters[shard][:0]
+			atomic.AddInt32(&q.trigger, -1)
+			q.release(n, 0)
+		}
+		q.unlock(shard)
+	}
+	q.wakeup()
+	if q.opts.onError != nil {
+		q.opts.onError(err, gts)
+	}
+}
+
 // lock shard.
 func (q *ShardQueue) lock(shard int32) {
 	for !atomic.CompareAndSwapInt32(&q.locks[shard], 0, 1) {
@@ -15,8 +15,11 @@
 package mux
 
 import (
+	"errors"
+	"io"
 	"net"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -71,6 +74,223 @@ func TestShardQueue(t *testing.T) {
 	Equal(t, rn, total)
 }
 
+func TestShardQueueClose(t *testing.T) {
+	network, address := "tcp", "127.0.0.1:8912"
+	ln, err := net.Listen(network, address)
+	MustNil(t, err)
+	defer ln.Close()
+	conn, err := netpoll.DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	svrConn, err := ln.Accept()
+	MustNil(t, err)
+	defer svrConn.Close()
+
+	queue := NewShardQueue(4, conn)
+	count, pkgsize := 16, 11
+	for i := 0; i < count; i++ {
+		MustNil(t, queue.Add(func() (buf netpoll.Writer, isNil bool) {
+			buf = netpoll.NewLinkBuffer(pkgsize)
+			buf.Malloc(pkgsize)
+			return buf, false
+		}))
+	}
+	// all the queued are flushed after closing
+	MustNil(t, queue.Close())
+	Equal(t, queue.Add(func() (buf netpoll.Writer, isNil bool) { return nil, true }), ErrQueueClosed)
+
+	total := count * pkgsize
+	recv := make([]byte, total)
+	_, err = io.ReadFull(svrConn, recv)
+	MustNil(t, err)
+	MustTrue(t, conn.IsActive())
+}
+
+var errMockAppend = errors.New("mock append failed")
+
+// mockWriter fails to append after n times, and waits for hold before appending if it's set.
+type mockWriter struct {
+	*netpoll.LinkBuffer
+	n       int
+	flushes int32
+	hold    chan struct{}
+}
+
+func (w *mockWriter) Append(writer netpoll.Writer) (n int, err error) {
+	if w.hold != nil {
+		<-w.hold
+	}
+	if w.n == 0 {
+		return 0, errMockAppend
+	}
+	w.n--
+	return w.LinkBuffer.Append(writer)
+}
+
+func (w *mockWriter) Flush() (err error) {
+	atomic.AddInt32(&w.flushes, 1)
+	return w.LinkBuffer.Flush()
+}
+
+type mockConn struct {
+	netpoll.Connection
+	writer *mockWriter
+	closed int32
+}
+
+func (c *mockConn) Writer() netpoll.Writer {
+	return c.writer
+}
+
+func (c *mockConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *mockConn) IsActive() bool {
+	return atomic.LoadInt32(&c.closed) == 0
+}
+
+func TestShardQueueError(t *testing.T) {
+	conn := &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: 2}}
+	var errs = make(chan error, 1)
+	var unsent int
+	queue := NewShardQueue(4, conn, WithErrorHandler(func(err error, gts []WriterGetter) {
+		unsent = len(gts)
+		errs <- err
+	}))
+	var called int32
+	var getter WriterGetter = func() (buf netpoll.Writer, isNil bool) {
+		atomic.AddInt32(&called, 1)
+		buf = netpoll.NewLinkBuffer()
+		buf.WriteString("hello")
+		return buf, false
+	}
+	MustNil(t, queue.Add(getter, getter, getter, getter, getter))
+	Equal(t, <-errs, errMockAppend)
+	// the 3rd one failed to append, and the others are not called
+	Equal(t, atomic.LoadInt32(&called), int32(3))
+	Equal(t, unsent, 2)
+	Equal(t, atomic.LoadInt32(&conn.closed), int32(1))
+	Equal(t, queue.Add(getter), errMockAppend)
+	Equal(t, queue.Close(), errMockAppend)
+}
+
+func TestShardQueueConnClosed(t *testing.T) {
+	conn := &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: -1}}
+	queue := NewShardQueue(4, conn)
+	var getter WriterGetter = func() (buf netpoll.Writer, isNil bool) {
+		return nil, true
+	}
+	MustNil(t, queue.Add(getter))
+	conn.Close()
+	MustTrue(t, errors.Is(queue.Add(getter), netpoll.ErrConnClosed))
+	MustNil(t, queue.Close())
+}
+
+func TestShardQueueLimit(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		conn := &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: -1}}
+		queue := NewShardQueue(4, conn, WithMaxQueued(2, 0, block))
+		var entered, proceed = make(chan struct{}), make(chan struct{})
+		MustNil(t, queue.Add(func() (buf netpoll.Writer, isNil bool) {
+			close(entered)
+			<-proceed
+			return nil, true
+		}))
+		// the dealing getter is not counted
+		<-entered
+		var getter WriterGetter = func() (buf netpoll.Writer, isNil bool) {
+			return nil, true
+		}
+		MustNil(t, queue.Add(getter))
+		MustNil(t, queue.Add(getter))
+		if !block {
+			Equal(t, queue.Add(getter), ErrQueueFull)
+			close(proceed)
+		} else {
+			time.AfterFunc(50*time.Millisecond, func() { close(proceed) })
+			begin := time.Now()
+			MustNil(t, queue.Add(getter))
+			MustTrue(t, time.Since(begin) >= 50*time.Millisecond)
+		}
+		MustNil(t, queue.Close())
+	}
+}
+
+func TestShardQueueLimitBytes(t *testing.T) {
+	var hold = make(chan struct{})
+	conn := &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: -1, hold: hold}}
+	queue := NewShardQueue(4, conn, WithMaxQueued(0, 200, false))
+	var called int32
+	var getter = func(n int) WriterGetter {
+		return func() (buf netpoll.Writer, isNil bool) {
+			atomic.AddInt32(&called, 1)
+			buf = netpoll.NewLinkBuffer()
+			buf.Malloc(n)
+			return buf, false
+		}
+	}
+	// the dealing one is not counted
+	MustNil(t, queue.Add(getter(100)))
+	var deadline = time.Now().Add(time.Second)
+	for queued := 1; queued > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the data is not dealt")
+		}
+		time.Sleep(time.Millisecond)
+		queue.mu.Lock()
+		queued = queue.queuedBytes
+		queue.mu.Unlock()
+	}
+	MustNil(t, queue.Add(getter(100)))
+	MustNil(t, queue.Add(getter(100)))
+	// the getters are called when adding
+	Equal(t, atomic.LoadInt32(&called), int32(3))
+	Equal(t, queue.Add(getter(1)), ErrQueueFull)
+	close(hold)
+	MustNil(t, queue.Close())
+	Equal(t, conn.writer.Len(), 300)
+}
+
+func TestShardQueueFlushPolicy(t *testing.T) {
+	conn := &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: -1}}
+	queue := NewShardQueue(4, conn, WithFlushPolicy(300, 0))
+	var gts []WriterGetter
+	for i := 0; i < 10; i++ {
+		gts = append(gts, func() (buf netpoll.Writer, isNil bool) {
+			buf = netpoll.NewLinkBuffer()
+			buf.Malloc(100)
+			return buf, false
+		})
+	}
+	MustNil(t, queue.Add(gts...))
+	MustNil(t, queue.Close())
+	// flushed by every 300 bytes, and the rest after sweeping
+	Equal(t, atomic.LoadInt32(&conn.writer.flushes), int32(4))
+	Equal(t, conn.writer.Len(), 1000)
+
+	// flushed by the timer while a getter is being called
+	conn = &mockConn{writer: &mockWriter{LinkBuffer: netpoll.NewLinkBuffer(), n: -1}}
+	queue = NewShardQueue(4, conn, WithFlushPolicy(0, 20*time.Millisecond))
+	var proceed = make(chan struct{})
+	MustNil(t, queue.Add(gts[0], func() (buf netpoll.Writer, isNil bool) {
+		<-proceed
+		return nil, true
+	}))
+	var deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt32(&conn.writer.flushes) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the batch is not flushed after the max delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(proceed)
+	MustNil(t, queue.Close())
+	Equal(t, atomic.LoadInt32(&conn.writer.flushes), int32(2))
+	Equal(t, conn.writer.Len(), 100)
+}
+
 // TODO: need mock flush
 func BenchmarkShardQueue(b *testing.B) {
 	b.Skip()
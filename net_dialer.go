@@ -17,6 +17,8 @@ package netpoll
 import (
 	"context"
 	"net"
+	"os"
+	"syscall"
 	"time"
 )
 
@@ -29,19 +31,41 @@ type Dialer interface {
 	DialTimeout(network, address string, timeout time.Duration) (conn net.Conn, err error)
 }
 
+// ContextDialer is an optional interface implemented by the Dialer created by NewDialer,
+// which is kept out of Dialer for compatibility with the other implementations. Use it by type assertion:
+//
+//	if cd, ok := dialer.(netpoll.ContextDialer); ok {
+//		conn, err := cd.DialContext(ctx, network, address)
+//	}
+type ContextDialer interface {
+	// DialContext connects to the address on the named network using the provided context,
+	// and the dialing is interrupted once the context is canceled or expired.
+	DialContext(ctx context.Context, network, address string) (connection Connection, err error)
+}
+
 // DialConnection is a default implementation of Dialer.
+// A zero timeout means no timeout, and the dialing waits until it's connected or failed.
 func DialConnection(network, address string, timeout time.Duration) (connection Connection, err error) {
 	return defaultDialer.DialConnection(network, address, timeout)
 }
 
-// NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
-	return &dialer{}
+// NewDialer only support TCP, UDP and unix socket now.
+func NewDialer(opts ...DialerOption) Dialer {
+	d := &dialer{}
+	d.opts.noDelay = true
+	for _, do := range opts {
+		do.f(&d.opts)
+	}
+	return d
 }
 
 var defaultDialer = NewDialer()
 
-type dialer struct{}
+type dialer struct {
+	opts dialerOptions
+}
+
+var _ ContextDialer = &dialer{}
 
 // DialTimeout implements Dialer.
 func (d *dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
@@ -57,31 +81,150 @@ func (d *dialer) DialConnection(network, address string, timeout time.Duration)
 		defer cancel()
 		ctx = subCtx
 	}
+	return d.DialContext(ctx, network, address)
+}
 
+// DialContext implements ContextDialer.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sd := &sysDialer{network: network, address: address}
+	if d.opts.reuseAddr || d.opts.sendBuffer > 0 || d.opts.recvBuffer > 0 {
+		sd.ctrlFn = d.control
+	}
 	switch network {
 	case "tcp", "tcp4", "tcp6":
-		var raddr *TCPAddr
-		raddr, err = ResolveTCPAddr(network, address)
-		if err != nil {
+		var laddr *TCPAddr
+		if laddr, err = d.tcpAddr(); err != nil {
+			return nil, err
+		}
+		var raddrs []*TCPAddr
+		if raddrs, err = sd.resolveTCPAddrs(ctx, laddr); err != nil {
+			return nil, err
+		}
+		// race all the addresses like Happy Eyeballs
+		var c *TCPConnection
+		if c, err = sd.dialParallel(ctx, laddr, raddrs); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddrs[0].opAddr(), Err: err}
+		}
+		if err = d.setup(c.fd); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	case "udp", "udp4", "udp6":
+		var laddr, raddr *UDPAddr
+		if raddr, err = ResolveUDPAddr(network, address); err != nil {
+			return nil, err
+		}
+		if laddr, err = d.udpAddr(); err != nil {
 			return nil, err
 		}
-		connection, err = DialTCP(ctx, network, nil, raddr)
-	// case "udp", "udp4", "udp6":  // TODO: unsupport now
+		var c *UDPConnection
+		if c, err = sd.dialUDP(ctx, laddr, raddr); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
+		}
+		return c, nil
 	case "unix", "unixgram", "unixpacket":
-		var raddr *UnixAddr
-		raddr, err = ResolveUnixAddr(network, address)
-		if err != nil {
+		var laddr, raddr *UnixAddr
+		if raddr, err = ResolveUnixAddr(network, address); err != nil {
+			return nil, err
+		}
+		if laddr, err = d.unixAddr(); err != nil {
 			return nil, err
 		}
-		connection, err = DialUnix(network, nil, raddr)
+		var c *UnixConnection
+		if c, err = sd.dialUnix(ctx, laddr, raddr); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
+		}
+		return c, nil
 	default:
 		return nil, net.UnknownNetworkError(network)
 	}
-	return connection, err
+}
+
+// control sets the socket options before binding and connecting.
+func (d *dialer) control(fd int) (err error) {
+	if d.opts.reuseAddr {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if d.opts.sendBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, d.opts.sendBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if d.opts.recvBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, d.opts.recvBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
+
+// setup sets the options of TCP connection after connected.
+func (d *dialer) setup(fd int) (err error) {
+	// TCP_NODELAY is enabled by connection.init
+	if !d.opts.noDelay {
+		if err = setTCPNoDelay(fd, false); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if d.opts.keepAlive > 0 {
+		secs := int((d.opts.keepAlive + time.Second - 1) / time.Second)
+		if err = SetKeepAlive(fd, secs); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
+
+func (d *dialer) tcpAddr() (*TCPAddr, error) {
+	switch addr := d.opts.localAddr.(type) {
+	case nil:
+		return nil, nil
+	case *TCPAddr:
+		return addr, nil
+	case *net.TCPAddr:
+		return &TCPAddr{TCPAddr: *addr}, nil
+	}
+	return nil, errLocalAddr(d.opts.localAddr)
+}
+
+func (d *dialer) udpAddr() (*UDPAddr, error) {
+	switch addr := d.opts.localAddr.(type) {
+	case nil:
+		return nil, nil
+	case *UDPAddr:
+		return addr, nil
+	case *net.UDPAddr:
+		return &UDPAddr{UDPAddr: *addr}, nil
+	}
+	return nil, errLocalAddr(d.opts.localAddr)
+}
+
+func (d *dialer) unixAddr() (*UnixAddr, error) {
+	switch addr := d.opts.localAddr.(type) {
+	case nil:
+		return nil, nil
+	case *UnixAddr:
+		return addr, nil
+	case *net.UnixAddr:
+		return &UnixAddr{UnixAddr: *addr}, nil
+	}
+	return nil, errLocalAddr(d.opts.localAddr)
+}
+
+func errLocalAddr(addr net.Addr) error {
+	return &net.AddrError{Err: "mismatched local address type", Addr: addr.String()}
 }
 
 // sysDialer contains a Dial's parameters and configuration.
 type sysDialer struct {
 	net.Dialer
 	network, address string
+	// ctrlFn is called after creating the socket but before binding and connecting.
+	ctrlFn func(fd int) error
 }
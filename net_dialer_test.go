@@ -16,7 +16,9 @@ package netpoll
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
@@ -30,7 +32,7 @@ func TestDialerTCP(t *testing.T) {
 	dialer := NewDialer()
 	conn, err := dialer.DialTimeout("tcp", ":1234", time.Second)
 	MustTrue(t, err != nil)
-	MustTrue(t, conn.(*TCPConnection) == nil)
+	MustTrue(t, conn == nil)
 
 	ln, err := CreateListener("tcp", ":1234")
 	MustNil(t, err)
@@ -64,7 +66,7 @@ func TestDialerUnix(t *testing.T) {
 	dialer := NewDialer()
 	conn, err := dialer.DialTimeout("unix", "tmp.sock", time.Second)
 	MustTrue(t, err != nil)
-	MustTrue(t, conn.(*UnixConnection) == nil)
+	MustTrue(t, conn == nil)
 
 	ln, err := CreateListener("unix", "tmp.sock")
 	MustNil(t, err)
@@ -256,3 +258,157 @@ func mockDialerSend(idx int, conn *connection) {
 		fmt.Printf("Error: conn[%d] client%d Next fail: %s", conn.fd, idx, err.Error())
 	}
 }
+
+func TestDialerOptions(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:8898")
+	MustNil(t, err)
+	defer ln.Close()
+
+	laddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8899}
+	dialer := NewDialer(
+		WithLocalAddr(laddr),
+		WithReuseAddr(),
+		WithTCPNoDelay(false),
+		WithKeepAlive(time.Minute),
+		WithSendBuffer(64*1024),
+		WithRecvBuffer(64*1024),
+	)
+	conn, err := dialer.DialConnection("tcp", "127.0.0.1:8898", time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	Equal(t, conn.LocalAddr().String(), laddr.String())
+
+	fd := conn.(*TCPConnection).fd
+	nodelay, err := syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	MustNil(t, err)
+	Equal(t, nodelay, 0)
+	keepalive, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	MustNil(t, err)
+	MustTrue(t, keepalive != 0)
+	reuse, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR)
+	MustNil(t, err)
+	MustTrue(t, reuse != 0)
+	// the kernel may double the buffer size for bookkeeping overhead
+	sndbuf, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	MustNil(t, err)
+	MustTrue(t, sndbuf >= 64*1024)
+	rcvbuf, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	MustNil(t, err)
+	MustTrue(t, rcvbuf >= 64*1024)
+
+	// mismatched local address
+	dialer = NewDialer(WithLocalAddr(&net.UnixAddr{Name: "tmp.sock", Net: "unix"}))
+	_, err = dialer.DialConnection("tcp", "127.0.0.1:8898", time.Second)
+	MustTrue(t, err != nil)
+}
+
+func TestDialerContext(t *testing.T) {
+	ln, err := CreateListener("unix", "dialer.test.sock")
+	MustNil(t, err)
+	defer ln.Close()
+
+	// canceled before dialing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dialer := NewDialer().(ContextDialer)
+	conn, err := dialer.DialContext(ctx, "unix", "dialer.test.sock")
+	MustTrue(t, errors.Is(err, errCanceled))
+	MustTrue(t, conn == nil)
+	conn, err = dialer.DialContext(ctx, "tcp", "127.0.0.1:8898")
+	MustTrue(t, errors.Is(err, errCanceled) || errors.Is(err, syscall.ECONNREFUSED))
+	MustTrue(t, conn == nil)
+	conn, err = dialer.DialContext(ctx, "udp", "127.0.0.1:8898")
+	MustTrue(t, errors.Is(err, errCanceled))
+	MustTrue(t, conn == nil)
+
+	// canceled while the connect is pending, the peer never accepts
+	fd, addr := backlogFullListener(t)
+	defer syscall.Close(fd)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	begin := time.Now()
+	_, err = dialer.DialContext(ctx, "tcp", addr)
+	MustTrue(t, errors.Is(err, errCanceled))
+	MustTrue(t, time.Since(begin) < time.Second)
+}
+
+func TestDialerNoDeadline(t *testing.T) {
+	// refused
+	_, err := DialConnection("tcp", "127.0.0.1:8903", 0)
+	MustTrue(t, errors.Is(err, syscall.ECONNREFUSED))
+	_, err = DialConnection("unix", "nonexist.test.sock", 0)
+	MustTrue(t, errors.Is(err, syscall.ENOENT))
+
+	// unreachable, fails by the network or is canceled, but never hangs
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	begin := time.Now()
+	_, err = NewDialer().(ContextDialer).DialContext(ctx, "tcp", "192.0.2.1:80")
+	MustTrue(t, err != nil)
+	MustTrue(t, time.Since(begin) < time.Second)
+
+	// slow accepting, connected once the peer drains its queue
+	fd, addr := backlogFullListener(t)
+	defer syscall.Close(fd)
+	type dialResult struct {
+		conn Connection
+		err  error
+	}
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := DialConnection("tcp", addr, 0)
+		done <- dialResult{conn, err}
+	}()
+	select {
+	case <-done:
+		t.Fatal("dial should be pending before accepting")
+	case <-time.After(200 * time.Millisecond):
+	}
+	MustNil(t, syscall.SetNonblock(fd, true))
+	for {
+		for {
+			nfd, _, err := syscall.Accept(fd)
+			if err != nil {
+				break
+			}
+			defer syscall.Close(nfd)
+		}
+		select {
+		case res := <-done:
+			MustNil(t, res.err)
+			Equal(t, res.conn.RemoteAddr().String(), addr)
+			res.conn.Close()
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// backlogFullListener returns a listening socket which never accepts, and its queue has been filled,
+// so that the following connects to it stay pending.
+func backlogFullListener(t *testing.T) (fd int, addr string) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	MustNil(t, err)
+	MustNil(t, syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	MustNil(t, syscall.Listen(fd, 0))
+	sa, err := syscall.Getsockname(fd)
+	MustNil(t, err)
+	addr = fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+	for i := 0; i < 8; i++ {
+		conn, err := DialConnection("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			if runtime.GOOS != "linux" {
+				t.Skip("the backlog of listener can't be filled")
+			}
+			return fd, addr
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	syscall.Close(fd)
+	t.Skip("the backlog of listener can't be filled")
+	return
+}
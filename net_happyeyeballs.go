@@ -0,0 +1,175 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// connectionAttemptDelay is the time to wait for an attempt before starting the next one,
+// as recommended by RFC 8305 (Happy Eyeballs Version 2).
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// resolveTCPAddrs resolves all the addresses of sd.address, and sorts them by interleaving the address families.
+// If laddr has a specified IP, only the addresses of the same family will be returned.
+func (sd *sysDialer) resolveTCPAddrs(ctx context.Context, laddr *TCPAddr) (raddrs []*TCPAddr, err error) {
+	host, service, err := net.SplitHostPort(sd.address)
+	if err != nil {
+		return nil, err
+	}
+	// IP literal or wildcard needn't lookup
+	if host == "" || net.ParseIP(splitHostZone(host)) != nil {
+		raddr, err := ResolveTCPAddr(sd.network, sd.address)
+		if err != nil {
+			return nil, err
+		}
+		return []*TCPAddr{raddr}, nil
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, sd.network, service)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []*TCPAddr
+	for _, ip := range ips {
+		addr := &TCPAddr{net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone}}
+		if ip.IP.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	switch sd.network {
+	case "tcp4":
+		v6 = nil
+	case "tcp6":
+		v4 = nil
+	}
+	if laddr != nil && len(laddr.IP) != 0 && !laddr.IP.IsUnspecified() {
+		if laddr.IP.To4() != nil {
+			v6 = nil
+		} else {
+			v4 = nil
+		}
+	}
+	if len(v4)+len(v6) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	// the family of the first address returned goes first
+	first, second := v6, v4
+	if ips[0].IP.To4() != nil {
+		first, second = v4, v6
+	}
+	return interleaveAddrs(first, second), nil
+}
+
+// interleaveAddrs alternates the addresses between two families, starting with first.
+func interleaveAddrs(first, second []*TCPAddr) (addrs []*TCPAddr) {
+	addrs = make([]*TCPAddr, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+	return addrs
+}
+
+// dialParallel races the connection attempts to raddrs, and returns the first established one.
+// The attempts are started in order with connectionAttemptDelay between them, and the next one is
+// started at once if the previous one fails. The other attempts are canceled or closed if lost.
+func (sd *sysDialer) dialParallel(ctx context.Context, laddr *TCPAddr, raddrs []*TCPAddr) (*TCPConnection, error) {
+	if len(raddrs) == 1 {
+		return sd.dialTCP(ctx, laddr, raddrs[0])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn *TCPConnection
+		err  error
+	}
+	// buffered, so that the losers never block
+	var results = make(chan dialResult, len(raddrs))
+	var next, pending int
+	var start = func() {
+		raddr := raddrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := sd.dialTCP(ctx, laddr, raddr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+	start()
+	var timer = time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(raddrs) {
+				start()
+				timer.Reset(connectionAttemptDelay)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				// close the losers which have been established
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if res := <-results; res.err == nil {
+							res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if next < len(raddrs) {
+				start()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// splitHostZone returns the host without the IPv6 zone.
+func splitHostZone(host string) string {
+	for i := len(host) - 1; i >= 0; i-- {
+		if host[i] == '%' {
+			return host[:i]
+		}
+	}
+	return host
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	var v4, v6 []*TCPAddr
+	for i := 1; i <= 3; i++ {
+		v4 = append(v4, &TCPAddr{net.TCPAddr{IP: net.IPv4(127, 0, 0, byte(i))}})
+	}
+	v6 = append(v6, &TCPAddr{net.TCPAddr{IP: net.IPv6loopback}})
+	addrs := interleaveAddrs(v6, v4)
+	Equal(t, len(addrs), 4)
+	Equal(t, addrs[0].IP.String(), "::1")
+	Equal(t, addrs[1].IP.String(), "127.0.0.1")
+	Equal(t, addrs[2].IP.String(), "127.0.0.2")
+	Equal(t, addrs[3].IP.String(), "127.0.0.3")
+}
+
+func TestDialParallel(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:8900")
+	MustNil(t, err)
+	defer ln.Close()
+	good, err := ResolveTCPAddr("tcp", "127.0.0.1:8900")
+	MustNil(t, err)
+	refused, err := ResolveTCPAddr("tcp", "127.0.0.1:8901")
+	MustNil(t, err)
+
+	sd := &sysDialer{network: "tcp"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the next attempt starts at once if the previous one fails
+	begin := time.Now()
+	conn, err := sd.dialParallel(ctx, nil, []*TCPAddr{refused, good})
+	MustNil(t, err)
+	MustTrue(t, time.Since(begin) < connectionAttemptDelay)
+	Equal(t, conn.RemoteAddr().String(), good.String())
+	conn.Close()
+
+	// the next attempt starts after the delay if the previous one hangs
+	fd, addr := backlogFullListener(t)
+	defer syscall.Close(fd)
+	pending, err := ResolveTCPAddr("tcp", addr)
+	MustNil(t, err)
+	begin = time.Now()
+	conn, err = sd.dialParallel(ctx, nil, []*TCPAddr{pending, good})
+	MustNil(t, err)
+	cost := time.Since(begin)
+	MustTrue(t, cost >= connectionAttemptDelay && cost < time.Second)
+	Equal(t, conn.RemoteAddr().String(), good.String())
+	conn.Close()
+
+	// all failed
+	_, err = sd.dialParallel(ctx, nil, []*TCPAddr{refused, refused})
+	MustTrue(t, err != nil)
+}
+
+func TestDialHostname(t *testing.T) {
+	if _, err := net.LookupIP("localhost"); err != nil {
+		t.Skip("localhost can't be resolved")
+	}
+	ln, err := CreateListener("tcp", ":8902")
+	MustNil(t, err)
+	defer ln.Close()
+	conn, err := DialConnection("tcp", "localhost:8902", time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	MustTrue(t, conn.RemoteAddr().(*net.TCPAddr).IP.IsLoopback())
+}
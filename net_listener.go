@@ -34,9 +34,9 @@ type Listener interface {
 
 // CreateListener return a new Listener.
 func CreateListener(network, addr string) (l Listener, err error) {
-	if network == "udp" {
-		// TODO: udp listener.
-		return udpListener(network, addr)
+	switch network {
+	case "udp", "udp4", "udp6":
+		return nil, Exception(ErrUnsupported, "use ListenPacket for "+network)
 	}
 	// tcp, tcp4, tcp6, unix
 	ln, err := net.Listen(network, addr)
@@ -61,41 +61,109 @@ func ConvertListener(l net.Listener) (nl Listener, err error) {
 	return ln, syscall.SetNonblock(ln.fd, true)
 }
 
-// TODO: udpListener does not work now.
-func udpListener(network, addr string) (l Listener, err error) {
-	ln := &listener{}
-	ln.pconn, err = net.ListenPacket(network, addr)
+// ConvertConn converts net.Conn of TCP or unix stream socket to Connection, such as the ones
+// rebuilt from inherited fds by net.FileConn.
+// The fd of conn is duplicated, so conn will be closed after converting.
+func ConvertConn(conn net.Conn) (c Connection, err error) {
+	if tmp, ok := conn.(Connection); ok {
+		return tmp, nil
+	}
+	defer conn.Close()
+	var file *os.File
+	switch netconn := conn.(type) {
+	case *net.TCPConn:
+		file, err = netconn.File()
+	case *net.UnixConn:
+		file, err = netconn.File()
+	default:
+		return nil, errors.New("conn type can't support")
+	}
 	if err != nil {
 		return nil, err
 	}
-	ln.addr = ln.pconn.LocalAddr()
-	switch pconn := ln.pconn.(type) {
-	case *net.UDPConn:
-		ln.file, err = pconn.File()
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	syscall.CloseOnExec(fd)
+	var nfd = &netFD{}
+	nfd.fd = fd
+	nfd.sotype = syscall.SOCK_STREAM
+	nfd.isStream = true
+	nfd.localAddr = conn.LocalAddr()
+	nfd.remoteAddr = conn.RemoteAddr()
+	if _, ok := conn.(*net.UnixConn); ok {
+		nfd.network, nfd.family = "unix", syscall.AF_UNIX
+		uc, err := newUnixConnection(nfd)
+		if err != nil {
+			return nil, err
+		}
+		return uc, nil
 	}
+	nfd.network = "tcp"
+	tc, err := newTCPConnection(nfd)
 	if err != nil {
 		return nil, err
 	}
-	ln.fd = int(ln.file.Fd())
-	return ln, syscall.SetNonblock(ln.fd, true)
+	return tc, nil
+}
+
+// ListenPacket announces on the local network address and returns a PacketConnection registered on the pollers.
+// The network must be a UDP network name.
+func ListenPacket(network, addr string, opts ...PacketOption) (conn PacketConnection, err error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	pconn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertPacketConn(pconn, opts...)
+}
+
+// ConvertPacketConn converts net.PacketConn to PacketConnection.
+// The fd of pconn is duplicated, so pconn will be closed after converting.
+func ConvertPacketConn(pconn net.PacketConn, opts ...PacketOption) (conn PacketConnection, err error) {
+	if tmp, ok := pconn.(PacketConnection); ok {
+		return tmp, nil
+	}
+	udpconn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return nil, errors.New("packet conn type can't support")
+	}
+	defer pconn.Close()
+	file, err := udpconn.File()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	syscall.CloseOnExec(fd)
+	var nfd = &netFD{}
+	nfd.fd = fd
+	nfd.sotype = syscall.SOCK_DGRAM
+	nfd.localAddr = pconn.LocalAddr()
+	nfd.network = pconn.LocalAddr().Network()
+	return newPacketConnection(nfd, opts...)
 }
 
 var _ net.Listener = &listener{}
 
 type listener struct {
-	fd    int
-	addr  net.Addr       // listener's local addr
-	ln    net.Listener   // tcp|unix listener
-	pconn net.PacketConn // udp listener
-	file  *os.File
+	fd   int
+	addr net.Addr     // listener's local addr
+	ln   net.Listener // tcp|unix listener
+	file *os.File
 }
 
 // Accept implements Listener.
 func (ln *listener) Accept() (net.Conn, error) {
-	// udp
-	if ln.pconn != nil {
-		return ln.UDPAccept()
-	}
 	// tcp
 	var fd, sa, err = syscall.Accept(ln.fd)
 	if err != nil {
@@ -112,11 +180,6 @@ func (ln *listener) Accept() (net.Conn, error) {
 	return nfd, nil
 }
 
-// TODO: UDPAccept Not implemented.
-func (ln *listener) UDPAccept() (net.Conn, error) {
-	return nil, Exception(ErrUnsupported, "UDP")
-}
-
 // Close implements Listener.
 func (ln *listener) Close() error {
 	if ln.fd != 0 {
@@ -128,9 +191,6 @@ func (ln *listener) Close() error {
 	if ln.ln != nil {
 		ln.ln.Close()
 	}
-	if ln.pconn != nil {
-		ln.pconn.Close()
-	}
 	return nil
 }
 
@@ -95,14 +95,15 @@ func (c *netFD) dial(ctx context.Context, laddr, raddr sockaddr) (err error) {
 	// 1) the one returned by the connect method, if any; or
 	// 2) the one from Getpeername, if it succeeds; or
 	// 3) the one passed to us as the raddr parameter.
+	var toAddr = c.addrFunc()
 	lsa, _ = syscall.Getsockname(c.fd)
-	c.localAddr = sockaddrToAddr(lsa)
+	c.localAddr = toAddr(lsa)
 	if crsa != nil {
-		c.remoteAddr = sockaddrToAddr(crsa)
+		c.remoteAddr = toAddr(crsa)
 	} else if crsa, _ = syscall.Getpeername(c.fd); crsa != nil {
-		c.remoteAddr = sockaddrToAddr(crsa)
+		c.remoteAddr = toAddr(crsa)
 	} else {
-		c.remoteAddr = sockaddrToAddr(rsa)
+		c.remoteAddr = toAddr(rsa)
 	}
 	return nil
 }
@@ -134,47 +135,8 @@ func (c *netFD) connect(ctx context.Context, la, ra syscall.Sockaddr) (rsa sysca
 		return nil, os.NewSyscallError("connect", err)
 	}
 
-	// TODO: can't support interrupter now.
-	// Start the "interrupter" goroutine, if this context might be canceled.
-	// (The background context cannot)
-	//
-	// The interrupter goroutine waits for the context to be done and
-	// interrupts the dial (by altering the c's write deadline, which
-	// wakes up waitWrite).
-	if ctx != context.Background() {
-		// Wait for the interrupter goroutine to exit before returning
-		// from connect.
-		done := make(chan struct{})
-		interruptRes := make(chan error)
-		defer func() {
-			close(done)
-			if ctxErr := <-interruptRes; ctxErr != nil && ret == nil {
-				// The interrupter goroutine called SetWriteDeadline,
-				// but the connect code below had returned from
-				// waitWrite already and did a successful connect (ret
-				// == nil). Because we've now poisoned the connection
-				// by making it unwritable, don't return a successful
-				// dial. This was issue 16523.
-				ret = mapErr(ctxErr)
-				c.Close() // prevent a leak
-			}
-		}()
-		go func() {
-			select {
-			case <-ctx.Done():
-				// Force the runtime's poller to immediately give up
-				// waiting for writability, unblocking waitWrite
-				// below.
-				c.SetWriteDeadline(aLongTimeAgo)
-				interruptRes <- ctx.Err()
-			case <-done:
-				interruptRes <- nil
-			}
-		}()
-	}
-
+	// The waiting is interrupted by WaitWrite itself once ctx is done.
 	c.pd = newPollDesc(c.fd)
-	var deadline, _ = ctx.Deadline()
 	for {
 		// Performing multiple connect system calls on a
 		// non-blocking socket under Unix variants does not
@@ -184,12 +146,19 @@ func (c *netFD) connect(ctx context.Context, la, ra syscall.Sockaddr) (rsa sysca
 		// SO_ERROR socket option to see if the connection
 		// succeeded or failed. See issue 7474 for further
 		// details.
-		if err := c.pd.WaitWrite(deadline); err != nil {
+		if err := c.pd.WaitWrite(ctx); err != nil {
 			select {
 			case <-ctx.Done():
 				return nil, mapErr(ctx.Err())
 			default:
 			}
+			// the poller reports hup when the connect failed, such as refused or unreachable,
+			// so prefer the detailed error of SO_ERROR.
+			if errors.Is(err, ErrConnClosed) {
+				if nerr, _ := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR); nerr != 0 {
+					return nil, os.NewSyscallError("connect", syscall.Errno(nerr))
+				}
+			}
 			return nil, err
 		}
 		nerr, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
@@ -15,6 +15,7 @@
 package netpoll
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -50,9 +51,9 @@ type pollDesc struct {
 	writeTicker chan error
 }
 
-// WaitWrite
-// TODO: implement - poll support timeout hung up.
-func (pd *pollDesc) WaitWrite(deadline time.Time) (err error) {
+// WaitWrite waits for the fd to be writable until the deadline of ctx, or ctx is canceled.
+// If ctx has no deadline, it waits without any timer until the poller wakes it up.
+func (pd *pollDesc) WaitWrite(ctx context.Context) (err error) {
 	// if writable, check hup by select
 	if pd.writable {
 		select {
@@ -63,12 +64,17 @@ func (pd *pollDesc) WaitWrite(deadline time.Time) (err error) {
 		}
 	}
 	// calling first time
-	if deadline.IsZero() {
-		return Exception(ErrDialNoDeadline, "")
-	}
-	dur := time.Until(deadline)
-	if dur <= 0 {
-		return Exception(ErrDialTimeout, dur.String())
+	var dur time.Duration
+	var timeout <-chan time.Time
+	if deadline, ok := ctx.Deadline(); ok {
+		dur = time.Until(deadline)
+		if dur <= 0 {
+			return Exception(ErrDialTimeout, dur.String())
+		}
+		// add timeout trigger
+		timer := time.NewTimer(dur)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 	// add ET|Write|Hup
 	pd.operator.poll = pollmanager.Pick()
@@ -77,21 +83,21 @@ func (pd *pollDesc) WaitWrite(deadline time.Time) (err error) {
 		pd.operator.Control(PollDetach)
 		return err
 	}
-	// add timeout trigger
-	cancel := time.AfterFunc(dur, func() {
-		select {
-		case pd.writeTicker <- Exception(ErrDialTimeout, dur.String()):
-		default:
-		}
-	})
-	// wait
-	if err = <-pd.writeTicker; err == nil {
+	// wait, the nil channels of timeout and ctx.Done() are never ready
+	select {
+	case err = <-pd.writeTicker:
+	case <-timeout:
+		err = Exception(ErrDialTimeout, dur.String())
+	case <-ctx.Done():
+		err = mapErr(ctx.Err())
+	}
+	if err == nil {
 		pd.writable = true
-	} else {
-		if errors.Is(err, ErrDialTimeout) {
-			pd.operator.Control(PollDetach)
-		}
+		return nil
+	}
+	// detach if the fd is still being watched
+	if !errors.Is(err, ErrConnClosed) {
+		pd.operator.Control(PollDetach)
 	}
-	cancel.Stop()
 	return err
 }
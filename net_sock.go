@@ -39,12 +39,12 @@ type sockaddr interface {
 	toLocal(net string) sockaddr
 }
 
-func internetSocket(ctx context.Context, net string, laddr, raddr sockaddr, sotype, proto int, mode string) (conn *netFD, err error) {
+func internetSocket(ctx context.Context, net string, laddr, raddr sockaddr, sotype, proto int, mode string, ctrlFn func(fd int) error) (conn *netFD, err error) {
 	if (runtime.GOOS == "aix" || runtime.GOOS == "windows" || runtime.GOOS == "openbsd" || runtime.GOOS == "nacl") && raddr.isWildcard() {
 		raddr = raddr.toLocal(net)
 	}
 	family, ipv6only := favoriteAddrFamily(net, laddr, raddr)
-	return socket(ctx, net, family, sotype, proto, ipv6only, laddr, raddr)
+	return socket(ctx, net, family, sotype, proto, ipv6only, laddr, raddr, ctrlFn)
 }
 
 // favoriteAddrFamily returns the appropriate address family for the
@@ -102,7 +102,7 @@ func favoriteAddrFamily(network string, laddr, raddr sockaddr) (family int, ipv6
 
 // socket returns a network file descriptor that is ready for
 // asynchronous I/O using the network poller.
-func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only bool, laddr, raddr sockaddr) (netfd *netFD, err error) {
+func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only bool, laddr, raddr sockaddr, ctrlFn func(fd int) error) (netfd *netFD, err error) {
 	// syscall.Socket & set socket options
 	var fd int
 	fd, err = sysSocket(family, sotype, proto)
@@ -114,6 +114,13 @@ func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only
 		syscall.Close(fd)
 		return nil, err
 	}
+	// set the socket options of dialer before bind and connect
+	if ctrlFn != nil {
+		if err = ctrlFn(fd); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
 
 	netfd = newNetFD(fd, family, sotype, net)
 	err = netfd.dial(ctx, laddr, raddr)
@@ -124,6 +131,17 @@ func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only
 	return netfd, nil
 }
 
+// addrFunc returns the function which converts syscall.Sockaddr to the address of netFD's network.
+func (c *netFD) addrFunc() func(syscall.Sockaddr) net.Addr {
+	switch c.sotype {
+	case syscall.SOCK_DGRAM:
+		if c.family != syscall.AF_UNIX {
+			return sockaddrToUDPAddr
+		}
+	}
+	return sockaddrToAddr
+}
+
 // sockaddrToAddr returns a go/net friendly address
 func sockaddrToAddr(sa syscall.Sockaddr) net.Addr {
 	var a net.Addr
@@ -0,0 +1,175 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// This file may have been modified by CloudWeGo authors. (“CloudWeGo Modifications”).
+// All CloudWeGo Modifications are Copyright 2021 CloudWeGo authors.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || nacl || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux nacl netbsd openbsd solaris
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// UDPAddr represents the address of a UDP end point.
+type UDPAddr struct {
+	net.UDPAddr
+}
+
+func (a *UDPAddr) isWildcard() bool {
+	if a == nil || a.IP == nil {
+		return true
+	}
+	return a.IP.IsUnspecified()
+}
+
+func (a *UDPAddr) opAddr() net.Addr {
+	if a == nil {
+		return nil
+	}
+	return a
+}
+
+func (a *UDPAddr) family() int {
+	if a == nil || len(a.IP) <= net.IPv4len {
+		return syscall.AF_INET
+	}
+	if a.IP.To4() != nil {
+		return syscall.AF_INET
+	}
+	return syscall.AF_INET6
+}
+
+func (a *UDPAddr) sockaddr(family int) (syscall.Sockaddr, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return ipToSockaddr(family, a.IP, a.Port, a.Zone)
+}
+
+func (a *UDPAddr) toLocal(network string) sockaddr {
+	addr := &UDPAddr{}
+	addr.IP = loopbackIP(network)
+	addr.Port = a.Port
+	addr.Zone = a.Zone
+	return addr
+}
+
+// ResolveUDPAddr returns an address of UDP end point.
+//
+// The network must be a UDP network name.
+//
+// If the host in the address parameter is not a literal IP address or
+// the port is not a literal port number, ResolveUDPAddr resolves the
+// address to an address of UDP end point.
+// Otherwise, it parses the address as a pair of literal IP address
+// and port number.
+func ResolveUDPAddr(network, address string) (*UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPAddr{*addr}, nil
+}
+
+// UDPConnection implements Connection.
+//
+// UDPConnection is a connected UDP socket, each Flush sends the submitted data as a single datagram,
+// and the received datagrams are appended to the input buffer in order.
+type UDPConnection struct {
+	connection
+}
+
+// newUDPConnection wraps UDPConnection.
+func newUDPConnection(conn Conn) (connection *UDPConnection, err error) {
+	connection = &UDPConnection{}
+	err = connection.init(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+// SetIdleTimeout implements Connection, which is ignored since UDP has no keepalive.
+func (c *UDPConnection) SetIdleTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// DialUDP acts like Dial for UDP networks.
+//
+// The network must be a UDP network name; see func Dial for details.
+//
+// If laddr is nil, a local address is automatically chosen.
+// If the IP field of raddr is nil or an unspecified IP address, the
+// local system is assumed.
+func DialUDP(ctx context.Context, network string, laddr, raddr *UDPAddr) (*UDPConnection, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: net.UnknownNetworkError(network)}
+	}
+	if raddr == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: nil, Err: errMissingAddress}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sd := &sysDialer{network: network, address: raddr.String()}
+	c, err := sd.dialUDP(ctx, laddr, raddr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
+	}
+	return c, nil
+}
+
+func (sd *sysDialer) dialUDP(ctx context.Context, laddr, raddr *UDPAddr) (*UDPConnection, error) {
+	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_DGRAM, 0, "dial", sd.ctrlFn)
+	if err != nil {
+		return nil, err
+	}
+	return newUDPConnection(conn)
+}
+
+// sockaddrToUDPAddr returns a go/net friendly UDP address.
+func sockaddrToUDPAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.UDPAddr{
+			IP:   sa.Addr[0:],
+			Port: sa.Port,
+		}
+	case *syscall.SockaddrInet6:
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{
+			IP:   sa.Addr[0:],
+			Port: sa.Port,
+			Zone: zone,
+		}
+	}
+	return nil
+}
+
+// udpAddrToSockaddr converts the UDP address to syscall.Sockaddr of the given family.
+func udpAddrToSockaddr(family int, addr net.Addr) (syscall.Sockaddr, error) {
+	if addr == nil {
+		return nil, errMissingAddress
+	}
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return ipToSockaddr(family, addr.IP, addr.Port, addr.Zone)
+	case *UDPAddr:
+		return ipToSockaddr(family, addr.IP, addr.Port, addr.Zone)
+	}
+	return nil, &net.AddrError{Err: "unexpected address type", Addr: addr.String()}
+}
@@ -68,7 +68,14 @@ func ResolveUnixAddr(network, address string) (*UnixAddr, error) {
 	return &UnixAddr{*addr}, nil
 }
 
-// UnixConnection implements Connection.
+// UnixCredentials is the credentials of the peer process passed by SCM_CREDENTIALS.
+type UnixCredentials struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// UnixConnection implements Connection and FDConnection.
 type UnixConnection struct {
 	connection
 }
@@ -104,14 +111,14 @@ func DialUnix(network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
 }
 
 func (sd *sysDialer) dialUnix(ctx context.Context, laddr, raddr *UnixAddr) (*UnixConnection, error) {
-	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial")
+	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial", sd.ctrlFn)
 	if err != nil {
 		return nil, err
 	}
 	return newUnixConnection(conn)
 }
 
-func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string) (conn *netFD, err error) {
+func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string, ctrlFn func(fd int) error) (conn *netFD, err error) {
 	var sotype int
 	switch network {
 	case "unix":
@@ -140,5 +147,5 @@ func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode
 		return nil, errors.New("unknown mode: " + mode)
 	}
 
-	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr)
+	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr, ctrlFn)
 }
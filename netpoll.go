@@ -22,6 +22,7 @@ import (
 	"net"
 	"runtime"
 	"sync"
+	"time"
 )
 
 // A EventLoop is a network server.
@@ -29,16 +30,54 @@ type EventLoop interface {
 	// Serve registers a listener and runs blockingly to provide services, including listening to ports,
 	// accepting connections and processing trans data. When an exception occurs or Shutdown is invoked,
 	// Serve will return an error which describes the specific reason.
+	//
+	// Serve can be called concurrently with different listeners, all of which share the same
+	// OnRequest and options. Each call returns when its own listener stops.
 	Serve(ln net.Listener) error
 
 	// Shutdown is used to graceful exit.
-	// It will close all idle connections on the server, but will not change the underlying pollers.
+	// It will close all listeners and idle connections on the server, but will not change the underlying pollers.
 	//
 	// Argument: ctx set the waiting deadline, after which an error will be returned,
 	// but will not force the closing of connections in progress.
 	Shutdown(ctx context.Context) error
 }
 
+// Inspector is an optional interface implemented by the EventLoop created by NewEventLoop,
+// which reports its running status without changing EventLoop. Use it by type assertion:
+//
+//	if inspector, ok := eventLoop.(netpoll.Inspector); ok {
+//		stats := inspector.Stats()
+//	}
+type Inspector interface {
+	// Stats returns the statistics of each listener being served, in the order Serve was called.
+	Stats() []ListenerStats
+
+	// Connections returns a snapshot of the live connections of all listeners being served,
+	// which can be used to find out the clients holding memory.
+	Connections() []ConnectionInfo
+}
+
+// ListenerStats describes the running status of a listener served by EventLoop.
+type ListenerStats struct {
+	Addr        net.Addr // listener's local addr
+	Accepted    uint64   // total sockets accepted
+	Rejected    uint64   // total sockets rejected by OnAccept
+	Connections int64    // live connections
+}
+
+// ConnectionInfo describes the running status of a connection served by EventLoop.
+type ConnectionInfo struct {
+	FD         int
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Poller     int           // index of the poller which the connection is registered to, -1 if unknown
+	InputLen   int           // bytes received but not read yet
+	OutputLen  int           // bytes flushed but not sent yet
+	Processing bool          // whether OnRequest is running
+	Age        time.Duration // time since accepted
+}
+
 // OnRequest defines the function for handling connection. When data is sent from the connection peer,
 // netpoll actively reads the data in LT mode and places it in the connection's input buffer.
 // Generally, OnRequest starts handling the data in the following way:
@@ -69,6 +108,15 @@ type EventLoop interface {
 // Return: error is unused which will be ignored directly.
 type OnRequest func(ctx context.Context, connection Connection) error
 
+// OnDisconnect is called once the connection is closed by the peer or broken, which is found by the poller.
+// Unlike the CloseCallback, which is delayed until the user closes the connection if OnRequest is not set,
+// it's called at once, so the user can close the idle connections without polling IsActive.
+// It's set by DisconnectNotifier.SetOnDisconnect.
+//
+// PLEASE NOTE:
+// OnDisconnect is called in the poller, so it must return quickly.
+type OnDisconnect func(connection Connection)
+
 // OnPrepare is used to inject custom preparation at connection initialization,
 // which is optional but important in some scenarios. For example, a qps limiter
 // can be set by closing overloaded connections directly in OnPrepare.
@@ -82,39 +130,56 @@ type OnRequest func(ctx context.Context, connection Connection) error
 // so Reader() or Writer() cannot be used here, but may be supported in the future.
 type OnPrepare func(connection Connection) context.Context
 
+// OnAccept is used to filter the accepted sockets by their remote address before any
+// connection resources are allocated, which makes it cheap to enforce allowlists or
+// per-IP connection caps.
+//
+// Return:
+// false means the socket is rejected and will be closed immediately,
+// OnPrepare and OnRequest will never be called for it.
+type OnAccept func(remote net.Addr) bool
+
 // NewEventLoop .
 func NewEventLoop(onRequest OnRequest, ops ...Option) (EventLoop, error) {
-	opt := &options{}
+	opt := &options{
+		onRequest: onRequest,
+	}
 	for _, do := range ops {
 		do.f(opt)
 	}
 	return &eventLoop{
-		opt:     opt,
-		prepare: opt.prepare(onRequest),
-		stop:    make(chan error, 1),
+		opt: opt,
 	}, nil
 }
 
 type eventLoop struct {
 	sync.Mutex
-	opt     *options
-	prepare OnPrepare
-	svr     *server
-	stop    chan error
+	opt  *options
+	svrs []*server
 }
 
+var _ Inspector = &eventLoop{}
+
 // Serve implements EventLoop.
 func (evl *eventLoop) Serve(ln net.Listener) error {
 	npln, err := ConvertListener(ln)
 	if err != nil {
 		return err
 	}
+	var stop = make(chan error, 1)
+	var svr = newServer(npln, evl.opt, func(err error) {
+		select {
+		case stop <- err:
+		default:
+		}
+	})
 	evl.Lock()
-	evl.svr = newServer(npln, evl.prepare, evl.quit)
-	evl.svr.Run()
+	evl.svrs = append(evl.svrs, svr)
+	svr.Run()
 	evl.Unlock()
 
-	err = evl.waitQuit()
+	err = <-stop
+	evl.remove(svr)
 	// ensure evl will not be finalized until Serve returns
 	runtime.SetFinalizer(evl, nil)
 	return err
@@ -123,25 +188,62 @@ func (evl *eventLoop) Serve(ln net.Listener) error {
 // Shutdown signals a shutdown a begins server closing.
 func (evl *eventLoop) Shutdown(ctx context.Context) error {
 	evl.Lock()
-	var svr = evl.svr
-	evl.svr = nil
+	var svrs = evl.svrs
+	evl.svrs = nil
 	evl.Unlock()
 
-	if svr == nil {
+	if len(svrs) == 0 {
 		return nil
 	}
-	evl.quit(nil)
-	return svr.Close(ctx)
+	// drain all listeners at the same time
+	var errs = make(chan error, len(svrs))
+	for _, svr := range svrs {
+		svr.quit(nil)
+		go func(svr *server) {
+			errs <- svr.Close(ctx)
+		}(svr)
+	}
+	var err error
+	for range svrs {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Stats implements Inspector.
+func (evl *eventLoop) Stats() []ListenerStats {
+	evl.Lock()
+	defer evl.Unlock()
+	var stats = make([]ListenerStats, len(evl.svrs))
+	for i, svr := range evl.svrs {
+		stats[i] = svr.stats()
+	}
+	return stats
 }
 
-// waitQuit waits for a quit signal
-func (evl *eventLoop) waitQuit() error {
-	return <-evl.stop
+// Connections implements Inspector.
+func (evl *eventLoop) Connections() []ConnectionInfo {
+	evl.Lock()
+	var svrs = evl.svrs
+	evl.Unlock()
+	var infos []ConnectionInfo
+	var now = time.Now()
+	for _, svr := range svrs {
+		infos = svr.connectionInfos(infos, now)
+	}
+	return infos
 }
 
-func (evl *eventLoop) quit(err error) {
-	select {
-	case evl.stop <- err:
-	default:
+// remove svr from the serving list, if it still exists.
+func (evl *eventLoop) remove(svr *server) {
+	evl.Lock()
+	defer evl.Unlock()
+	for i := range evl.svrs {
+		if evl.svrs[i] == svr {
+			evl.svrs = append(evl.svrs[:i], evl.svrs[i+1:]...)
+			return
+		}
 	}
 }
@@ -0,0 +1,256 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Handoffer is an optional interface implemented by the EventLoop created by NewEventLoop,
+// which is used to restart without downtime. Use it by type assertion:
+//
+//	if handoffer, ok := eventLoop.(netpoll.Handoffer); ok {
+//		err = handoffer.Handoff(ctx, path, true)
+//	}
+type Handoffer interface {
+	// Handoff stops accepting, and hands the listeners being served, and the idle connections if withConns is true,
+	// to the successor process waiting by ReceiveHandoff on the unix socket path,
+	// and then drains and exits like Shutdown.
+	//
+	// If the successor fails to receive them, Handoff returns an error, and the listeners and connections
+	// are attached back so that EventLoop continues serving. The data which the successor has read
+	// from the connections before failing is lost.
+	Handoff(ctx context.Context, path string, withConns bool) error
+}
+
+var _ Handoffer = &eventLoop{}
+
+// The handoff protocol over the unix socket: the predecessor sends one kind byte for each fd,
+// which is passed along with the byte, and ends with handoffEnd. The successor replies
+// handoffAck after all of them have been converted.
+const (
+	handoffListener = 'L'
+	handoffConn     = 'C'
+	handoffEnd      = 'E'
+	handoffAck      = 'A'
+)
+
+// defaultHandoffTimeout is used if the context of handoff has no deadline.
+const defaultHandoffTimeout = 10 * time.Second
+
+// Handoff implements Handoffer.
+func (evl *eventLoop) Handoff(ctx context.Context, path string, withConns bool) (err error) {
+	var deadline, ok = ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultHandoffTimeout)
+	}
+	conn, err := DialConnection("unix", path, time.Until(deadline))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var hc = conn.(FDConnection)
+
+	evl.Lock()
+	var svrs = append([]*server{}, evl.svrs...)
+	evl.Unlock()
+	// Stop accepting before handing off, so that no connection is accepted after the idle ones are sent.
+	// The sockets queued in the listeners will be accepted by the successor.
+	for i := range svrs {
+		svrs[i].operator.Control(PollDetach)
+	}
+	var conns []*connection
+	defer func() {
+		if err == nil {
+			return
+		}
+		// restore the listeners and connections, which are still owned by this process.
+		for i := range svrs {
+			svrs[i].operator.Control(PollReadable)
+		}
+		for i := range conns {
+			conns[i].attach()
+		}
+	}()
+	for i := range svrs {
+		if _, err = hc.WriteFDs([]int{svrs[i].ln.Fd()}, []byte{handoffListener}); err != nil {
+			return err
+		}
+	}
+	if withConns {
+		for i := range svrs {
+			if conns, err = svrs[i].handoff(hc, conns); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err = hc.WriteFDs(nil, []byte{handoffEnd}); err != nil {
+		return err
+	}
+	conn.SetReadTimeout(time.Until(deadline))
+	ack, err := conn.Reader().ReadByte()
+	if err != nil {
+		return err
+	}
+	if ack != handoffAck {
+		return fmt.Errorf("unexpected handoff ack: %q", ack)
+	}
+	// The connections have been owned by the successor.
+	for i := range conns {
+		conns[i].Close()
+	}
+	// The socket file of unix listener must be kept for the successor.
+	for i := range svrs {
+		if ln, ok := svrs[i].ln.(*listener); ok {
+			if uln, ok := ln.ln.(*net.UnixListener); ok {
+				uln.SetUnlinkOnClose(false)
+			}
+		}
+	}
+	return evl.Shutdown(ctx)
+}
+
+// handoff detaches the idle connections and sends them to the successor, and returns them appended to conns,
+// which are closed after the successor acknowledged, or attached back if failed.
+func (s *server) handoff(hc FDConnection, conns []*connection) ([]*connection, error) {
+	var err error
+	s.connections.Range(func(key, value interface{}) bool {
+		var conn, ok = value.(*connection)
+		if !ok || !conn.detach() {
+			return true
+		}
+		conns = append(conns, conn)
+		_, err = hc.WriteFDs([]int{conn.fd}, []byte{handoffConn})
+		return err == nil
+	})
+	return conns, err
+}
+
+// ReceiveHandoff listens on the unix socket path, and waits for the predecessor to hand off
+// by Handoffer.Handoff until ctx is done. The listeners received can be served by a new EventLoop,
+// and the connections received need to be set OnRequest by SetOnRequest.
+func ReceiveHandoff(ctx context.Context, path string) (lns []Listener, conns []Connection, err error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+
+	// cancel the blocking accept and read when ctx is done.
+	var done = make(chan struct{})
+	defer close(done)
+	var accepted atomic.Value // value is Connection
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+			if conn, _ := accepted.Load().(Connection); conn != nil {
+				conn.Close()
+			}
+		case <-done:
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+	conn, err := ConvertConn(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	accepted.Store(conn)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	var hc = conn.(FDConnection)
+
+	var kinds []byte
+	for {
+		kind, err := conn.Reader().ReadByte()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			return nil, nil, err
+		}
+		if kind == handoffEnd {
+			break
+		}
+		kinds = append(kinds, kind)
+	}
+	conn.Reader().Release()
+
+	// fds are received no later than the kinds.
+	var fds = hc.ReadFDs()
+	for i := range fds {
+		if i >= len(kinds) || err != nil {
+			syscall.Close(fds[i])
+			continue
+		}
+		var file = os.NewFile(uintptr(fds[i]), "")
+		switch kinds[i] {
+		case handoffListener:
+			var l net.Listener
+			if l, err = net.FileListener(file); err == nil {
+				var nl Listener
+				if nl, err = ConvertListener(l); err == nil {
+					lns = append(lns, nl)
+				}
+			}
+		case handoffConn:
+			var nc net.Conn
+			if nc, err = net.FileConn(file); err == nil {
+				var c Connection
+				if c, err = ConvertConn(nc); err == nil {
+					conns = append(conns, c)
+				}
+			}
+		default:
+			err = fmt.Errorf("unexpected handoff kind: %q", kinds[i])
+		}
+		file.Close()
+	}
+	if err == nil && len(fds) != len(kinds) {
+		err = errors.New("handoff fds mismatched")
+	}
+	if err == nil {
+		conn.Writer().WriteByte(handoffAck)
+		err = conn.Writer().Flush()
+	}
+	if err != nil {
+		for i := range lns {
+			lns[i].Close()
+		}
+		for i := range conns {
+			conns[i].Close()
+		}
+		return nil, nil, err
+	}
+	return lns, conns, nil
+}
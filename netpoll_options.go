@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"context"
+	"net"
 	"time"
 )
 
@@ -51,6 +52,40 @@ func WithOnPrepare(onPrepare OnPrepare) Option {
 	}}
 }
 
+// WithOnAccept registers the OnAccept method to EventLoop.
+func WithOnAccept(onAccept OnAccept) Option {
+	return Option{func(op *options) {
+		op.onAccept = onAccept
+	}}
+}
+
+// WithRejectReset makes the sockets rejected by OnAccept be closed with SO_LINGER 0,
+// so that the peer receives an RST instead of a graceful FIN.
+func WithRejectReset() Option {
+	return Option{func(op *options) {
+		op.rejectReset = true
+	}}
+}
+
+// WithProxyProtocol enables parsing the PROXY protocol v1/v2 header sent by L4 load balancers.
+// The header is stripped before OnRequest ever runs, and RemoteAddr/LocalAddr of the connection
+// are overridden by the addresses it carries, but OnPrepare still sees the addresses of the balancer.
+// The connection is closed if the header is invalid, or not received within timeout (0 means no timeout).
+func WithProxyProtocol(mode ProxyProtocolMode, timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.proxyProtocol = &proxyProtocol{mode: mode, timeout: timeout}
+	}}
+}
+
+// WithFrameDecoder makes OnRequest only be called when a complete frame has been received,
+// and the Reader of the connection passed to OnRequest is a zero-copy slice of the frame,
+// which is released after OnRequest returns. The connection is closed if the decoder fails.
+func WithFrameDecoder(decoder FrameDecoder) Option {
+	return Option{func(op *options) {
+		op.frameDecoder = decoder
+	}}
+}
+
 // WithReadTimeout sets the read timeout of connections.
 func WithReadTimeout(timeout time.Duration) Option {
 	return Option{func(op *options) {
@@ -65,25 +100,124 @@ func WithIdleTimeout(timeout time.Duration) Option {
 	}}
 }
 
+// WithBatchSize sets the maximum number of datagrams read or written by one system call of PacketConnection.
+func WithBatchSize(size int) PacketOption {
+	return PacketOption{func(op *packetOptions) {
+		if size > 0 {
+			op.batchSize = size
+		}
+	}}
+}
+
+// WithMaxPacketSize sets the maximum size of datagrams received by PacketConnection,
+// and the exceeded bytes will be discarded. UDP GRO is only enabled when size is not less than 64KB.
+func WithMaxPacketSize(size int) PacketOption {
+	return PacketOption{func(op *packetOptions) {
+		if size > 0 && size <= maxPacketSize {
+			op.packetSize = size
+		}
+	}}
+}
+
+// WithLocalAddr sets the local address used to dial, which must match the network dialed,
+// such as *net.TCPAddr for "tcp" and *net.UnixAddr for "unix".
+func WithLocalAddr(addr net.Addr) DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.localAddr = addr
+	}}
+}
+
+// WithTCPNoDelay sets the TCP_NODELAY flag of the dialed TCP connections, it's enabled by default.
+func WithTCPNoDelay(noDelay bool) DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.noDelay = noDelay
+	}}
+}
+
+// WithKeepAlive enables TCP keepalive of the dialed TCP connections with the period of probes.
+func WithKeepAlive(period time.Duration) DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.keepAlive = period
+	}}
+}
+
+// WithSendBuffer sets the SO_SNDBUF of the dialed sockets.
+func WithSendBuffer(size int) DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.sendBuffer = size
+	}}
+}
+
+// WithRecvBuffer sets the SO_RCVBUF of the dialed sockets.
+func WithRecvBuffer(size int) DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.recvBuffer = size
+	}}
+}
+
+// WithReuseAddr sets the SO_REUSEADDR of the dialed sockets, which is useful to bind a fixed local port.
+func WithReuseAddr() DialerOption {
+	return DialerOption{func(op *dialerOptions) {
+		op.reuseAddr = true
+	}}
+}
+
 // Option .
 type Option struct {
 	f func(*options)
 }
 
 type options struct {
-	onPrepare   OnPrepare
-	readTimeout time.Duration
-	idleTimeout time.Duration
+	onRequest     OnRequest
+	onPrepare     OnPrepare
+	onAccept      OnAccept
+	rejectReset   bool
+	proxyProtocol *proxyProtocol
+	frameDecoder  FrameDecoder
+	readTimeout   time.Duration
+	idleTimeout   time.Duration
 }
 
-func (opt *options) prepare(onRequest OnRequest) OnPrepare {
+func (opt *options) prepare() OnPrepare {
 	return func(connection Connection) context.Context {
-		connection.SetOnRequest(onRequest)
+		connection.SetOnRequest(opt.onRequest)
 		connection.SetReadTimeout(opt.readTimeout)
 		connection.SetIdleTimeout(opt.idleTimeout)
+		var ctx = context.Background()
 		if opt.onPrepare != nil {
-			return opt.onPrepare(connection)
+			ctx = opt.onPrepare(connection)
+		}
+		// wrap the OnRequest which may be replaced by OnPrepare.
+		if opt.frameDecoder != nil {
+			wrapFrameDecoder(connection, opt.frameDecoder)
 		}
-		return context.Background()
+		if opt.proxyProtocol != nil {
+			opt.proxyProtocol.wrap(connection)
+		}
+		return ctx
 	}
 }
+
+// PacketOption .
+type PacketOption struct {
+	f func(*packetOptions)
+}
+
+type packetOptions struct {
+	batchSize  int
+	packetSize int
+}
+
+// DialerOption .
+type DialerOption struct {
+	f func(*dialerOptions)
+}
+
+type dialerOptions struct {
+	localAddr  net.Addr
+	noDelay    bool
+	reuseAddr  bool
+	keepAlive  time.Duration
+	sendBuffer int
+	recvBuffer int
+}
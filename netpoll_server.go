@@ -20,21 +20,28 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // newServer wrap listener into server, quit will be invoked when server exit.
-func newServer(ln Listener, prepare OnPrepare, quit func(err error)) *server {
+func newServer(ln Listener, opts *options, quit func(err error)) *server {
 	return &server{
 		ln:      ln,
-		prepare: prepare,
+		opts:    opts,
+		prepare: opts.prepare(),
 		quit:    quit,
 	}
 }
 
 type server struct {
+	// statistics, must be at the top for 64-bit alignment
+	accepted    uint64
+	rejected    uint64
+	active      int64
 	operator    FDOperator
 	ln          Listener
+	opts        *options
 	prepare     OnPrepare
 	quit        func(err error)
 	connections sync.Map // key=fd, value=connection
@@ -109,6 +116,13 @@ func (s *server) OnRead(p Poll) error {
 	if conn == nil {
 		return nil
 	}
+	atomic.AddUint64(&s.accepted, 1)
+	// filter socket before allocating any connection resources
+	if s.opts.onAccept != nil && !s.opts.onAccept(conn.RemoteAddr()) {
+		atomic.AddUint64(&s.rejected, 1)
+		s.reject(conn.(Conn))
+		return nil
+	}
 	// store & register connection
 	var connection = &connection{}
 	connection.init(conn.(Conn), s.prepare)
@@ -116,14 +130,51 @@ func (s *server) OnRead(p Poll) error {
 		return nil
 	}
 	var fd = conn.(Conn).Fd()
+	atomic.AddInt64(&s.active, 1)
 	connection.AddCloseCallback(func(connection Connection) error {
 		s.connections.Delete(fd)
+		atomic.AddInt64(&s.active, -1)
 		return nil
 	})
 	s.connections.Store(fd, connection)
+	if s.opts.proxyProtocol != nil {
+		s.opts.proxyProtocol.watch(connection)
+	}
 	return nil
 }
 
+// stats returns the statistics of this server.
+func (s *server) stats() ListenerStats {
+	return ListenerStats{
+		Addr:        s.ln.Addr(),
+		Accepted:    atomic.LoadUint64(&s.accepted),
+		Rejected:    atomic.LoadUint64(&s.rejected),
+		Connections: atomic.LoadInt64(&s.active),
+	}
+}
+
+// connectionInfos appends the information of live connections to infos.
+func (s *server) connectionInfos(infos []ConnectionInfo, now time.Time) []ConnectionInfo {
+	s.connections.Range(func(key, value interface{}) bool {
+		if c, ok := value.(*connection); ok && c.IsActive() {
+			infos = append(infos, c.info(now))
+		}
+		return true
+	})
+	return infos
+}
+
+// reject closes the socket refused by OnAccept, and resets it if required.
+func (s *server) reject(conn Conn) {
+	// closed gracefully if the linger can't be set
+	if s.opts.rejectReset {
+		if err := setLinger(conn.Fd(), 0); err != nil {
+			log.Println("reset rejected conn failed:", err.Error())
+		}
+	}
+	conn.Close()
+}
+
 // OnHup implements FDOperator.
 func (s *server) OnHup(p Poll) error {
 	s.quit(errors.New("listener close"))
@@ -17,6 +17,12 @@ package netpoll
 import (
 	"context"
 	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -120,6 +126,321 @@ func TestGracefulExit(t *testing.T) {
 	MustNil(t, err)
 }
 
+func TestOnAccept(t *testing.T) {
+	var network, address = "tcp", ":8889"
+	var accepted, requested int32
+	var onAccept = func(remote net.Addr) bool {
+		// reject the first socket only
+		return atomic.AddInt32(&accepted, 1) > 1
+	}
+	var eventLoop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			atomic.AddInt32(&requested, 1)
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithOnAccept(onAccept),
+		WithRejectReset(),
+	)
+	defer eventLoop.Shutdown(context.Background())
+
+	// the reset may arrive before the dialer returns
+	rejected, err := DialConnection(network, address, time.Second)
+	var deadline = time.Now().Add(time.Second)
+	for err == nil && rejected.IsActive() {
+		if time.Now().After(deadline) {
+			t.Fatal("the rejected connection is not reset")
+		}
+		runtime.Gosched()
+	}
+	Equal(t, atomic.LoadInt32(&requested), int32(0))
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt32(&requested) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the accepted connection is not requested")
+		}
+		runtime.Gosched()
+	}
+	MustTrue(t, conn.IsActive())
+	Equal(t, atomic.LoadInt32(&accepted), int32(2))
+	conn.Close()
+}
+
+func TestServeMultiListeners(t *testing.T) {
+	var requested int32
+	var eventLoop, _ = NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			atomic.AddInt32(&requested, 1)
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		})
+	var inspector, ok = eventLoop.(Inspector)
+	MustTrue(t, ok)
+	var addrs = [][2]string{{"tcp", ":8890"}, {"tcp", ":8891"}, {"unix", "multi.test.sock"}}
+	var served = make(chan error, len(addrs))
+	for _, addr := range addrs {
+		var ln, err = CreateListener(addr[0], addr[1])
+		MustNil(t, err)
+		go func() {
+			served <- eventLoop.Serve(ln)
+		}()
+	}
+	var deadline = time.Now().Add(time.Second)
+	for len(inspector.Stats()) < len(addrs) {
+		if time.Now().After(deadline) {
+			t.Fatal("the listeners are not served")
+		}
+		runtime.Gosched()
+	}
+
+	for i, addr := range addrs {
+		conn, err := DialConnection(addr[0], addr[1], time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		deadline = time.Now().Add(time.Second)
+		for atomic.LoadInt32(&requested) < int32(i+1) {
+			if time.Now().After(deadline) {
+				t.Fatal("the connection is not requested", addr[1])
+			}
+			runtime.Gosched()
+		}
+	}
+	for _, stats := range inspector.Stats() {
+		Equal(t, stats.Accepted, uint64(1))
+		Equal(t, stats.Connections, int64(1))
+	}
+
+	var err = eventLoop.Shutdown(context.Background())
+	MustNil(t, err)
+	for range addrs {
+		MustNil(t, <-served)
+	}
+	Equal(t, len(inspector.Stats()), 0)
+}
+
+func TestConnections(t *testing.T) {
+	var network, address = "tcp", ":8923"
+	var hold = make(chan struct{})
+	var release sync.Once
+	defer release.Do(func() { close(hold) })
+	var eventLoop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			if s, _ := connection.Reader().Peek(4); string(s) == "hold" {
+				<-hold
+			}
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		})
+	defer eventLoop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	idle, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	busy, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = busy.Write([]byte("hold"))
+	MustNil(t, err)
+
+	var infos []ConnectionInfo
+	var deadline = time.Now().Add(time.Second)
+	for len(infos) < 2 || infos[0].InputLen+infos[1].InputLen < 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("the connections are not reported", infos)
+		}
+		runtime.Gosched()
+		infos = eventLoop.(Inspector).Connections()
+	}
+	for _, info := range infos {
+		MustTrue(t, info.Poller >= 0)
+		MustTrue(t, info.Age > 0)
+		MustTrue(t, info.FD > 0)
+		MustTrue(t, strings.HasSuffix(info.LocalAddr.String(), address))
+		switch info.RemoteAddr.String() {
+		case busy.LocalAddr().String():
+			Equal(t, info.InputLen, 4)
+			MustTrue(t, info.Processing)
+		case idle.LocalAddr().String():
+			Equal(t, info.InputLen, 0)
+			MustTrue(t, !info.Processing)
+		default:
+			t.Fatal("unknown connection", info.RemoteAddr)
+		}
+	}
+
+	release.Do(func() { close(hold) })
+	idle.Close()
+	busy.Close()
+	deadline = time.Now().Add(time.Second)
+	for len(eventLoop.(Inspector).Connections()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed connections are still reported")
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	var network, address, path = "tcp", ":8892", "handoff.test.sock"
+	var echo = func(tag string) OnRequest {
+		return func(ctx context.Context, connection Connection) error {
+			var p, err = connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			connection.Writer().WriteString(tag + string(p))
+			return connection.Writer().Flush()
+		}
+	}
+	var ln, err = CreateListener(network, address)
+	MustNil(t, err)
+	var old, _ = NewEventLoop(echo("old:"))
+	var served = make(chan error, 1)
+	go func() {
+		served <- old.Serve(ln)
+	}()
+	var deadline = time.Now().Add(time.Second)
+	for len(old.(Inspector).Stats()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the listener is not served")
+		}
+		runtime.Gosched()
+	}
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	conn.Writer().WriteString("ping")
+	MustNil(t, conn.Writer().Flush())
+	s, err := conn.Reader().ReadString(8)
+	MustNil(t, err)
+	Equal(t, s, "old:ping")
+
+	// successor receives the listener and idle connection
+	type received struct {
+		lns   []Listener
+		conns []Connection
+		err   error
+	}
+	var ch = make(chan received, 1)
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		lns, conns, err := ReceiveHandoff(ctx, path)
+		ch <- received{lns, conns, err}
+	}()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	MustNil(t, old.(Handoffer).Handoff(ctx, path, true))
+	MustNil(t, <-served)
+	var recv = <-ch
+	MustNil(t, recv.err)
+	Equal(t, len(recv.lns), 1)
+	Equal(t, len(recv.conns), 1)
+	_, err = os.Stat(path)
+	MustTrue(t, os.IsNotExist(err))
+
+	var successor, _ = NewEventLoop(echo("new:"))
+	go successor.Serve(recv.lns[0])
+	defer successor.Shutdown(context.Background())
+	defer recv.conns[0].Close()
+	MustNil(t, recv.conns[0].SetOnRequest(echo("new:")))
+
+	// the connection handed off is still alive
+	conn.Writer().WriteString("ping")
+	MustNil(t, conn.Writer().Flush())
+	s, err = conn.Reader().ReadString(8)
+	MustNil(t, err)
+	Equal(t, s, "new:ping")
+
+	// the listener handed off accepts new connections
+	conn2, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn2.Close()
+	conn2.Writer().WriteString("ping")
+	MustNil(t, conn2.Writer().Flush())
+	s, err = conn2.Reader().ReadString(8)
+	MustNil(t, err)
+	Equal(t, s, "new:ping")
+}
+
+func TestHandoffFailed(t *testing.T) {
+	var network, address, path = "tcp", ":8926", "handoff-failed.test.sock"
+	var eventLoop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			var p, err = connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			connection.Writer().WriteBinary(p)
+			return connection.Writer().Flush()
+		})
+	defer eventLoop.Shutdown(context.Background())
+	var deadline = time.Now().Add(time.Second)
+	for len(eventLoop.(Inspector).Stats()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the listener is not served")
+		}
+		runtime.Gosched()
+	}
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	deadline = time.Now().Add(time.Second)
+	for len(eventLoop.(Inspector).Connections()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the connection is not accepted")
+		}
+		runtime.Gosched()
+	}
+
+	// the successor rejects the handoff
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		var c, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var p = make([]byte, 1)
+		for p[0] != handoffEnd {
+			if _, err = c.Read(p); err != nil {
+				return
+			}
+		}
+		c.Write([]byte("X"))
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	MustTrue(t, eventLoop.(Handoffer).Handoff(ctx, path, true) != nil)
+
+	// the connection and listener are still served
+	conn.Writer().WriteString("ping")
+	MustNil(t, conn.Writer().Flush())
+	s, err := conn.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "ping")
+	conn2, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn2.Close()
+	conn2.Writer().WriteString("pong")
+	MustNil(t, conn2.Writer().Flush())
+	s, err = conn2.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "pong")
+}
+
 func newTestEventLoop(network, address string, handler OnRequest, opts ...Option) EventLoop {
 	var listener, _ = CreateListener(network, address)
 	var eventLoop, _ = NewEventLoop(handler, opts...)
@@ -0,0 +1,277 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpolltest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// FaultOption .
+type FaultOption struct {
+	f func(*faultOptions)
+}
+
+type faultOptions struct {
+	seed          int64
+	readDelay     time.Duration
+	readSplit     int
+	writeSplit    int
+	writeDelay    time.Duration
+	stallRate     float64
+	stall         time.Duration
+	resetAfter    int64
+	resetRate     float64
+	halfCloseAt   int64
+	halfCloseRate float64
+}
+
+// WithSeed sets the seed of random faults, so that they can be reproduced.
+func WithSeed(seed int64) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.seed = seed
+	}}
+}
+
+// WithReadDelay delays the data received by a random duration up to max for each chunk.
+func WithReadDelay(max time.Duration) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.readDelay = max
+	}}
+}
+
+// WithReadSplit splits the data received into chunks of random sizes up to maxChunk,
+// and each chunk is a separate node of the input buffer, so that Next and Peek have to span nodes.
+func WithReadSplit(maxChunk int) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.readSplit = maxChunk
+	}}
+}
+
+// WithShortWrites sends the data flushed in chunks of random sizes up to maxChunk with delay between them,
+// like the partial writes of a congested socket.
+func WithShortWrites(maxChunk int, delay time.Duration) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.writeSplit = maxChunk
+		op.writeDelay = delay
+	}}
+}
+
+// WithStalls stalls reading or writing a chunk for duration with probability, which emulates the storms of
+// EAGAIN, since netpoll hides EAGAIN from Reader and Writer.
+func WithStalls(probability float64, duration time.Duration) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.stallRate = probability
+		op.stall = duration
+	}}
+}
+
+// WithReset resets the connection abruptly by RST once afterBytes have been transferred (0 means never),
+// or at random with probability for each chunk.
+func WithReset(afterBytes int64, probability float64) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.resetAfter = afterBytes
+		op.resetRate = probability
+	}}
+}
+
+// WithHalfClose shuts down the writing side once afterBytes have been written (0 means never),
+// or at random with probability for each chunk. The data not sent is dropped, and Flush fails with EPIPE since then,
+// while reading still works.
+func WithHalfClose(afterBytes int64, probability float64) FaultOption {
+	return FaultOption{func(op *faultOptions) {
+		op.halfCloseAt = afterBytes
+		op.halfCloseRate = probability
+	}}
+}
+
+// Inject wraps conn to inject faults into its data transmission, which takes over the OnRequest of conn,
+// so it must be called before any data is received. The returned Connection must be used instead of conn.
+//
+// The faults of RST and half-close require conn to be a socket with Fd, otherwise conn is just closed.
+func Inject(conn netpoll.Connection, opts ...FaultOption) netpoll.Connection {
+	var in = &injector{raw: conn}
+	in.opts.seed = time.Now().UnixNano()
+	for _, do := range opts {
+		do.f(&in.opts)
+	}
+	in.rand = rand.New(rand.NewSource(in.opts.seed))
+	var c = newConn()
+	c.localAddr, c.remoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	c.send, c.hangup = in.send, func() { conn.Close() }
+	in.conn = c
+	conn.SetOnRequest(in.onRequest)
+	conn.AddCloseCallback(func(connection netpoll.Connection) error {
+		c.onHup()
+		return nil
+	})
+	return c
+}
+
+// OnPrepare returns netpoll.OnPrepare which injects faults into the accepted connections, and sets onRequest
+// to handle them, so it's used with netpoll.WithOnPrepare instead of the OnRequest of EventLoop.
+// The optional prepare is called with the wrapped connection, which returns the context of onRequest.
+func OnPrepare(onRequest netpoll.OnRequest, prepare netpoll.OnPrepare, opts ...FaultOption) netpoll.OnPrepare {
+	return func(connection netpoll.Connection) context.Context {
+		var c = Inject(connection, opts...)
+		var ctx = context.Background()
+		if prepare != nil {
+			ctx = prepare(c)
+		}
+		if onRequest != nil {
+			c.SetOnRequest(func(_ context.Context, connection netpoll.Connection) error {
+				return onRequest(ctx, connection)
+			})
+		}
+		return ctx
+	}
+}
+
+// injector transfers data between the raw connection and the wrapped one with faults.
+type injector struct {
+	opts        faultOptions
+	raw         netpoll.Connection
+	conn        *conn
+	mu          sync.Mutex // protects rand and the counters
+	rand        *rand.Rand
+	transferred int64
+	wroteBytes  int64
+	halfClosed  bool
+}
+
+// onRequest moves the data received by the raw connection into the wrapped one in chunks.
+func (in *injector) onRequest(ctx context.Context, raw netpoll.Connection) error {
+	var reader = raw.Reader()
+	p, err := reader.ReadBinary(reader.Len())
+	if err != nil {
+		return err
+	}
+	reader.Release()
+	for len(p) > 0 {
+		var n, reset = in.next(len(p), in.opts.readSplit, false)
+		if reset {
+			return nil
+		}
+		if in.opts.readDelay > 0 {
+			time.Sleep(time.Duration(in.int63n(int64(in.opts.readDelay) + 1)))
+		}
+		// one node for each chunk
+		var buf = netpoll.NewLinkBuffer()
+		buf.WriteBinary(p[:n])
+		buf.Flush()
+		in.conn.receive(buf)
+		p = p[n:]
+	}
+	return nil
+}
+
+// send writes the data flushed by the wrapped connection to the raw one in chunks.
+func (in *injector) send(output *netpoll.LinkBuffer) error {
+	p, err := output.ReadBinary(output.Len())
+	if err != nil {
+		return err
+	}
+	var w = in.raw.Writer()
+	for len(p) > 0 {
+		var n, reset = in.next(len(p), in.opts.writeSplit, true)
+		if reset {
+			return netpoll.Exception(netpoll.ErrConnClosed, "reset by fault")
+		}
+		if n == 0 {
+			return syscall.EPIPE
+		}
+		w.WriteBinary(p[:n])
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		p = p[n:]
+		in.wrote(n)
+		if in.opts.writeDelay > 0 && len(p) > 0 {
+			time.Sleep(in.opts.writeDelay)
+		}
+	}
+	return nil
+}
+
+// next returns the size of next chunk to transfer, which is 0 if half-closed, and resets the connection if faulted.
+// Stalls happen before the chunk, and the chunk ends at the bytes scheduled to reset or half-close.
+func (in *injector) next(size, max int, write bool) (n int, reset bool) {
+	in.mu.Lock()
+	var opts = &in.opts
+	if opts.stallRate > 0 && in.rand.Float64() < opts.stallRate {
+		in.mu.Unlock()
+		time.Sleep(opts.stall)
+		in.mu.Lock()
+	}
+	if write && in.halfClosed {
+		in.mu.Unlock()
+		return 0, false
+	}
+	n = size
+	if max > 0 && n > 1 {
+		if n > max {
+			n = max
+		}
+		n = 1 + int(in.rand.Int63n(int64(n)))
+	}
+	if at := opts.resetAfter; at > 0 && in.transferred < at && in.transferred+int64(n) > at {
+		n = int(at - in.transferred)
+	}
+	if at := opts.halfCloseAt; write && at > 0 && in.wroteBytes < at && in.wroteBytes+int64(n) > at {
+		n = int(at - in.wroteBytes)
+	}
+	reset = opts.resetAfter > 0 && in.transferred >= opts.resetAfter ||
+		opts.resetRate > 0 && in.rand.Float64() < opts.resetRate
+	in.transferred += int64(n)
+	in.mu.Unlock()
+
+	if reset {
+		if fd, ok := in.raw.(interface{ Fd() int }); ok {
+			syscall.SetsockoptLinger(fd.Fd(), syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+		}
+		in.raw.Close()
+	}
+	return n, reset
+}
+
+// wrote counts the bytes written, and shuts down the writing side if faulted.
+func (in *injector) wrote(n int) {
+	in.mu.Lock()
+	in.wroteBytes += int64(n)
+	var halfClose = !in.halfClosed && (in.opts.halfCloseAt > 0 && in.wroteBytes >= in.opts.halfCloseAt ||
+		in.opts.halfCloseRate > 0 && in.rand.Float64() < in.opts.halfCloseRate)
+	in.halfClosed = in.halfClosed || halfClose
+	in.mu.Unlock()
+
+	if !halfClose {
+		return
+	}
+	if fd, ok := in.raw.(interface{ Fd() int }); ok {
+		syscall.Shutdown(fd.Fd(), syscall.SHUT_WR)
+	} else {
+		in.raw.Close()
+	}
+}
+
+func (in *injector) int63n(n int64) int64 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.rand.Int63n(n)
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpolltest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func TestInjectReadSplit(t *testing.T) {
+	client, server := Pipe()
+	server = Inject(server, WithSeed(1), WithReadSplit(1), WithReadDelay(5*time.Millisecond))
+	var lens = make(chan int, 1)
+	var msgs = make(chan string, 1)
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		lens <- reader.Len()
+		// spans the nodes of chunks
+		p, err := reader.Peek(10)
+		if err != nil {
+			return err
+		}
+		msgs <- string(p)
+		reader.Skip(10)
+		return reader.Release()
+	})
+
+	client.Writer().WriteString("0123456789")
+	MustNil(t, client.Writer().Flush())
+	Equal(t, <-msgs, "0123456789")
+	Equal(t, <-lens, 1)
+	client.Close()
+}
+
+func TestInjectShortWrites(t *testing.T) {
+	client, server := Pipe()
+	client = Inject(client, WithSeed(1), WithShortWrites(3, 5*time.Millisecond))
+	var chunks = make(chan string, 16)
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		s, err := reader.ReadString(reader.Len())
+		chunks <- s
+		return err
+	})
+
+	client.Writer().WriteString("hello world")
+	MustNil(t, client.Writer().Flush())
+	var got string
+	var count int
+	for len(got) < 11 {
+		var s = <-chunks
+		MustTrue(t, len(s) <= 3)
+		got += s
+		count++
+	}
+	Equal(t, got, "hello world")
+	MustTrue(t, count >= 4)
+	client.Close()
+}
+
+func TestInjectStalls(t *testing.T) {
+	client, server := Pipe()
+	server = Inject(server, WithStalls(1, 20*time.Millisecond))
+	var received = make(chan time.Time, 1)
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		received <- time.Now()
+		return connection.Reader().Skip(connection.Reader().Len())
+	})
+
+	var start = time.Now()
+	client.Writer().WriteString("ping")
+	MustNil(t, client.Writer().Flush())
+	MustTrue(t, (<-received).Sub(start) >= 20*time.Millisecond)
+	client.Close()
+}
+
+func newTestEventLoop(t *testing.T, address string, onRequest netpoll.OnRequest, opts ...FaultOption) (netpoll.EventLoop, net.Conn) {
+	listener, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(nil, netpoll.WithOnPrepare(OnPrepare(onRequest, nil, opts...)))
+	MustNil(t, err)
+	go loop.Serve(listener)
+	time.Sleep(10 * time.Millisecond)
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	return loop, conn
+}
+
+func TestInjectReset(t *testing.T) {
+	var requests = make(chan string, 1)
+	var flushed = make(chan error, 1)
+	loop, conn := newTestEventLoop(t, ":8920", func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		s, err := reader.ReadString(reader.Len())
+		if err != nil {
+			return err
+		}
+		requests <- s
+		connection.Writer().WriteString(s)
+		err = connection.Writer().Flush()
+		flushed <- err
+		return err
+	}, WithReset(4, 0))
+	defer loop.Shutdown(context.Background())
+	defer conn.Close()
+
+	// reset when echoing, since 4 bytes have been received
+	_, err := conn.Write([]byte("ping"))
+	MustNil(t, err)
+	Equal(t, <-requests, "ping")
+	MustTrue(t, <-flushed != nil)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	MustTrue(t, errors.Is(err, syscall.ECONNRESET))
+}
+
+func TestInjectHalfClose(t *testing.T) {
+	var requests = make(chan string, 2)
+	var flushed = make(chan error, 2)
+	loop, conn := newTestEventLoop(t, ":8921", func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		s, err := reader.ReadString(reader.Len())
+		if err != nil {
+			return err
+		}
+		requests <- s
+		connection.Writer().WriteString(s)
+		err = connection.Writer().Flush()
+		flushed <- err
+		return err
+	}, WithHalfClose(5, 0))
+	defer loop.Shutdown(context.Background())
+	defer conn.Close()
+
+	_, err := conn.Write([]byte("hello world"))
+	MustNil(t, err)
+	Equal(t, <-requests, "hello world")
+	Equal(t, <-flushed, syscall.EPIPE)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	p, err := ioutil.ReadAll(conn)
+	MustNil(t, err)
+	Equal(t, string(p), "hello")
+
+	// still readable after half-closed
+	_, err = conn.Write([]byte("again"))
+	MustNil(t, err)
+	Equal(t, <-requests, "again")
+	err = <-flushed
+	MustTrue(t, err != nil && strings.Contains(err.Error(), "broken pipe"))
+}
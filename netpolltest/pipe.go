@@ -0,0 +1,461 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpolltest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Package netpolltest provides utilities to test the handlers of netpoll without sockets or pollers.
+ *
+ * Pipe: create a pair of in-memory Connections, the data flushed by one is read by the other,
+ * which runs OnRequest and the close callbacks like netpoll.Connection.
+ *
+ * Inject: wrap a Connection to inject faults on a schedule or at random, such as delayed and split reads,
+ * short writes, stalls, RSTs and half-closes. OnPrepare does the same for the connections accepted by EventLoop.
+ */
+
+// Pipe creates a pair of in-memory connections backed by LinkBuffers, the data flushed to one is received
+// by the other. Like netpoll.Connection, OnRequest is scheduled when data arrives, and runs while there is
+// data to read; closing one closes both, and the close callbacks run once the OnRequest task exits.
+// The close callbacks of the peer run at once if it has OnRequest, otherwise they run when it's closed by user.
+func Pipe() (netpoll.Connection, netpoll.Connection) {
+	var c1, c2 = newConn(), newConn()
+	c1.send, c1.hangup = c2.receive, c2.onHup
+	c2.send, c2.hangup = c1.receive, c1.onHup
+	return c1, c2
+}
+
+const (
+	active int32 = iota
+	closedByUser
+	closedByPeer
+)
+
+func newConn() *conn {
+	return &conn{
+		input:       netpoll.NewLinkBuffer(),
+		output:      netpoll.NewLinkBuffer(),
+		readTrigger: make(chan struct{}, 1),
+		localAddr:   pipeAddr{},
+		remoteAddr:  pipeAddr{},
+	}
+}
+
+// conn is an in-memory connection, which receives data into input, and sends the data flushed by send.
+type conn struct {
+	send        func(output *netpoll.LinkBuffer) error // hands the data flushed over
+	hangup      func()                                 // notifies the other end when closed by user
+	mu          sync.Mutex                             // protects input, which is written by the peer
+	input       *netpoll.LinkBuffer
+	output      *netpoll.LinkBuffer
+	readTrigger chan struct{}
+	readTimeout time.Duration
+	localAddr   net.Addr
+	remoteAddr  net.Addr
+	state       int32 // active, closedByUser or closedByPeer
+	processing  int32
+	process     atomic.Value // value is netpoll.OnRequest
+	disconnect  atomic.Value // value is netpoll.OnDisconnect
+	cbMu        sync.Mutex
+	callbacks   []netpoll.CloseCallback
+}
+
+var _ netpoll.Connection = &conn{}
+var _ netpoll.Reader = &conn{}
+var _ netpoll.Writer = &conn{}
+var _ netpoll.DisconnectNotifier = &conn{}
+
+// Reader implements netpoll.Connection.
+func (c *conn) Reader() netpoll.Reader {
+	return c
+}
+
+// Writer implements netpoll.Connection.
+func (c *conn) Writer() netpoll.Writer {
+	return c
+}
+
+// IsActive implements netpoll.Connection.
+func (c *conn) IsActive() bool {
+	return atomic.LoadInt32(&c.state) == active
+}
+
+// SetReadTimeout implements netpoll.Connection.
+func (c *conn) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		c.readTimeout = timeout
+	}
+	return nil
+}
+
+// SetIdleTimeout implements netpoll.Connection, which is ignored since there is no keepalive.
+func (c *conn) SetIdleTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// SetOnRequest implements netpoll.Connection.
+func (c *conn) SetOnRequest(onRequest netpoll.OnRequest) error {
+	if onRequest != nil {
+		c.process.Store(onRequest)
+	}
+	return nil
+}
+
+// SetOnDisconnect implements netpoll.DisconnectNotifier, which is called when the peer closes.
+func (c *conn) SetOnDisconnect(onDisconnect netpoll.OnDisconnect) error {
+	if onDisconnect != nil {
+		c.disconnect.Store(onDisconnect)
+	}
+	return nil
+}
+
+// AddCloseCallback implements netpoll.Connection, the callbacks run in the reverse order of adding.
+func (c *conn) AddCloseCallback(callback netpoll.CloseCallback) error {
+	if callback != nil {
+		c.cbMu.Lock()
+		c.callbacks = append(c.callbacks, callback)
+		c.cbMu.Unlock()
+	}
+	return nil
+}
+
+// ------------------------------------------ implement zero-copy reader ------------------------------------------
+
+// Next implements netpoll.Reader.
+func (c *conn) Next(n int) (p []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Next(n)
+}
+
+// Peek implements netpoll.Reader.
+func (c *conn) Peek(n int) (buf []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return buf, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Peek(n)
+}
+
+// Skip implements netpoll.Reader.
+func (c *conn) Skip(n int) (err error) {
+	if err = c.waitRead(n); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Skip(n)
+}
+
+// ReadString implements netpoll.Reader.
+func (c *conn) ReadString(n int) (s string, err error) {
+	if err = c.waitRead(n); err != nil {
+		return s, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.ReadString(n)
+}
+
+// ReadBinary implements netpoll.Reader.
+func (c *conn) ReadBinary(n int) (p []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.ReadBinary(n)
+}
+
+// ReadByte implements netpoll.Reader.
+func (c *conn) ReadByte() (b byte, err error) {
+	if err = c.waitRead(1); err != nil {
+		return b, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.ReadByte()
+}
+
+// Slice implements netpoll.Reader.
+func (c *conn) Slice(n int) (r netpoll.Reader, err error) {
+	if err = c.waitRead(n); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Slice(n)
+}
+
+// Release implements netpoll.Reader.
+func (c *conn) Release() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Release()
+}
+
+// Len implements netpoll.Reader.
+func (c *conn) Len() (length int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Len()
+}
+
+// ------------------------------------------ implement zero-copy writer ------------------------------------------
+
+// Malloc implements netpoll.Writer.
+func (c *conn) Malloc(n int) (buf []byte, err error) {
+	return c.output.Malloc(n)
+}
+
+// MallocLen implements netpoll.Writer.
+func (c *conn) MallocLen() (length int) {
+	return c.output.MallocLen()
+}
+
+// MallocAck implements netpoll.Writer.
+func (c *conn) MallocAck(n int) (err error) {
+	return c.output.MallocAck(n)
+}
+
+// Append implements netpoll.Writer.
+func (c *conn) Append(w netpoll.Writer) (n int, err error) {
+	return c.output.Append(w)
+}
+
+// WriteString implements netpoll.Writer.
+func (c *conn) WriteString(s string) (n int, err error) {
+	return c.output.WriteString(s)
+}
+
+// WriteBinary implements netpoll.Writer, b is copied since the peer would see it changed after flushed.
+func (c *conn) WriteBinary(b []byte) (n int, err error) {
+	buf, err := c.output.Malloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf, b), nil
+}
+
+// WriteDirect implements netpoll.Writer.
+func (c *conn) WriteDirect(p []byte, remainCap int) (err error) {
+	return c.output.WriteDirect(p, remainCap)
+}
+
+// WriteByte implements netpoll.Writer.
+func (c *conn) WriteByte(b byte) (err error) {
+	return c.output.WriteByte(b)
+}
+
+// Flush implements netpoll.Writer, which hands the data written over to the other end.
+func (c *conn) Flush() error {
+	if !c.IsActive() {
+		return netpoll.Exception(netpoll.ErrConnClosed, "when flush")
+	}
+	var output = c.output
+	c.output = netpoll.NewLinkBuffer()
+	if output.MallocLen() == 0 {
+		return nil
+	}
+	output.Flush()
+	return c.send(output)
+}
+
+// ------------------------------------------ implement net.Conn ------------------------------------------
+
+// Read implements net.Conn.
+func (c *conn) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err = c.waitRead(1); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var l = len(p)
+	if has := c.input.Len(); has < l {
+		l = has
+	}
+	src, err := c.input.Next(l)
+	n = copy(p, src)
+	if err == nil {
+		err = c.input.Release()
+	}
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *conn) Write(p []byte) (n int, err error) {
+	dst, _ := c.output.Malloc(len(p))
+	n = copy(dst, p)
+	return n, c.Flush()
+}
+
+// Close implements net.Conn, which closes both ends.
+func (c *conn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.state, active, closedByUser) {
+		c.triggerRead()
+		c.hangup()
+		c.closeCallback(true)
+		return nil
+	}
+	// the callbacks without OnRequest rely on closing by user
+	if atomic.LoadInt32(&c.state) == closedByPeer {
+		c.closeCallback(true)
+	}
+	return nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RemoteAddr implements net.Conn.
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// SetDeadline implements net.Conn.
+func (c *conn) SetDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetDeadline")
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetReadDeadline")
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetWriteDeadline")
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// ------------------------------------------ private ------------------------------------------
+
+// receive appends buf to input, whose nodes are kept, and schedules OnRequest.
+func (c *conn) receive(buf *netpoll.LinkBuffer) error {
+	c.mu.Lock()
+	c.input.WriteBuffer(buf)
+	c.input.Flush()
+	c.mu.Unlock()
+	c.triggerRead()
+	c.onRequest()
+	return nil
+}
+
+// onHup is called when the other end closes.
+func (c *conn) onHup() {
+	if !atomic.CompareAndSwapInt32(&c.state, active, closedByPeer) {
+		return
+	}
+	c.triggerRead()
+	if c.process.Load() != nil {
+		c.closeCallback(true)
+	}
+	if onDisconnect, _ := c.disconnect.Load().(netpoll.OnDisconnect); onDisconnect != nil {
+		onDisconnect(c)
+	}
+}
+
+// onRequest runs OnRequest in a new task if there is no one running.
+func (c *conn) onRequest() {
+	var handler, _ = c.process.Load().(netpoll.OnRequest)
+	if handler == nil || !atomic.CompareAndSwapInt32(&c.processing, 0, 1) {
+		return
+	}
+	go func() {
+		var ctx = context.Background()
+	START:
+		for c.Len() > 0 && c.IsActive() {
+			handler(ctx, c)
+		}
+		if !c.IsActive() {
+			c.closeCallback(false)
+			return
+		}
+		atomic.StoreInt32(&c.processing, 0)
+		// double check when exiting
+		if c.Len() > 0 {
+			if !atomic.CompareAndSwapInt32(&c.processing, 0, 1) {
+				return
+			}
+			goto START
+		}
+	}()
+}
+
+// closeCallback runs the callbacks once, which waits for the OnRequest task exiting if needLock.
+func (c *conn) closeCallback(needLock bool) {
+	if needLock && !atomic.CompareAndSwapInt32(&c.processing, 0, 1) {
+		return
+	}
+	c.cbMu.Lock()
+	var callbacks = c.callbacks
+	c.callbacks = nil
+	c.cbMu.Unlock()
+	for i := len(callbacks) - 1; i >= 0; i-- {
+		callbacks[i](c)
+	}
+}
+
+func (c *conn) triggerRead() {
+	select {
+	case c.readTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// waitRead waits for n bytes until timeout or closed.
+func (c *conn) waitRead(n int) error {
+	var timeout <-chan time.Time
+	if c.readTimeout > 0 {
+		var timer = time.NewTimer(c.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for c.Len() < n {
+		if !c.IsActive() {
+			return netpoll.Exception(netpoll.ErrConnClosed, "wait read")
+		}
+		select {
+		case <-c.readTrigger:
+		case <-timeout:
+			if c.Len() >= n {
+				return nil
+			}
+			return netpoll.Exception(netpoll.ErrReadTimeout, "pipe")
+		}
+	}
+	return nil
+}
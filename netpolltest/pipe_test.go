@@ -0,0 +1,184 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpolltest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	client, server := Pipe()
+	// echo the lines
+	var requests = make(chan string, 8)
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		line, err := reader.ReadString(reader.Len())
+		if err != nil {
+			return err
+		}
+		requests <- line
+		connection.Writer().WriteString(line)
+		return connection.Writer().Flush()
+	})
+
+	client.Writer().WriteString("hello")
+	MustNil(t, client.Writer().Flush())
+	s, err := client.Reader().ReadString(5)
+	MustNil(t, err)
+	Equal(t, s, "hello")
+	Equal(t, <-requests, "hello")
+
+	// net.Conn
+	n, err := client.Write([]byte("world"))
+	MustNil(t, err)
+	Equal(t, n, 5)
+	var buf = make([]byte, 16)
+	n, err = client.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "world")
+
+	// the data is copied when written
+	var p = []byte("abc")
+	client.Writer().WriteBinary(p)
+	p[0] = 'x'
+	MustNil(t, client.Writer().Flush())
+	s, err = client.Reader().ReadString(3)
+	MustNil(t, err)
+	Equal(t, s, "abc")
+}
+
+func TestPipeReadTimeout(t *testing.T) {
+	client, server := Pipe()
+	client.SetReadTimeout(10 * time.Millisecond)
+	var start = time.Now()
+	_, err := client.Reader().Next(1)
+	MustTrue(t, errors.Is(err, netpoll.ErrReadTimeout))
+	MustTrue(t, time.Since(start) >= 10*time.Millisecond)
+
+	// wait for the data flushed later
+	client.SetReadTimeout(time.Second)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		server.Writer().WriteString("ab")
+		server.Writer().Flush()
+	}()
+	s, err := client.Reader().ReadString(2)
+	MustNil(t, err)
+	Equal(t, s, "ab")
+}
+
+func TestPipeClose(t *testing.T) {
+	client, server := Pipe()
+	var closed = make(chan string, 4)
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		return connection.Reader().Release()
+	})
+	server.AddCloseCallback(func(connection netpoll.Connection) error {
+		closed <- "server"
+		return nil
+	})
+	client.AddCloseCallback(func(connection netpoll.Connection) error {
+		closed <- "client"
+		return nil
+	})
+
+	// the peer with OnRequest runs the callbacks at once
+	MustNil(t, client.Close())
+	MustTrue(t, !client.IsActive() && !server.IsActive())
+	Equal(t, <-closed, "server")
+	Equal(t, <-closed, "client")
+	_, err := server.Reader().Next(1)
+	MustTrue(t, errors.Is(err, netpoll.ErrConnClosed))
+	MustTrue(t, server.Writer().Flush() != nil)
+
+	// the peer without OnRequest runs the callbacks when closed by user
+	client, server = Pipe()
+	server.AddCloseCallback(func(connection netpoll.Connection) error {
+		closed <- "server"
+		return nil
+	})
+	MustNil(t, client.Close())
+	Equal(t, len(closed), 0)
+	MustNil(t, server.Close())
+	MustNil(t, server.Close())
+	Equal(t, <-closed, "server")
+	Equal(t, len(closed), 0)
+}
+
+func TestPipeCloseInOnRequest(t *testing.T) {
+	client, server := Pipe()
+	var running, trigger = make(chan struct{}), make(chan struct{})
+	var closed = make(chan struct{})
+	server.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		close(running)
+		<-trigger
+		return connection.Close()
+	})
+	// the callbacks run after OnRequest exits
+	server.AddCloseCallback(func(connection netpoll.Connection) error {
+		close(closed)
+		return nil
+	})
+	client.Writer().WriteString("bye")
+	MustNil(t, client.Writer().Flush())
+	<-running
+	client.Close()
+	select {
+	case <-closed:
+		t.Fatal("close callbacks run while OnRequest is running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(trigger)
+	<-closed
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/bytedance/gopkg/lang/mcache"
+)
+
+// Allocator allocates the memory of LinkBuffer, such as a slab allocator, an arena,
+// or an allocator backed by mmap huge pages. It's mcache by default.
+type Allocator interface {
+	// Malloc returns a slice whose length is size and capacity is at least capacity.
+	Malloc(size, capacity int) []byte
+	// Free recycles buf returned by Malloc, which will never be used by netpoll since then.
+	// The length of buf may be changed, but the capacity is kept.
+	Free(buf []byte)
+}
+
+// SetAllocator replaces the allocator of LinkBuffer, nil means mcache.
+//
+// PLEASE NOTE:
+// It must be called before creating any LinkBuffer or Connection, generally in init,
+// otherwise the memory allocated by the previous allocator may be freed to the new one.
+func SetAllocator(a Allocator) {
+	if a == nil {
+		a = mcacheAllocator{}
+	}
+	allocator.Store(allocatorHolder{a})
+}
+
+// SizeClassStats describes the memory allocated by Allocator but not freed yet in a size class.
+type SizeClassStats struct {
+	Size  int   // the upper bound of capacity, which is a power of 2
+	Count int64 // buffers outstanding
+	Bytes int64 // total capacity of the buffers outstanding
+}
+
+// AllocatorStats returns the memory outstanding of the size classes which have ever been allocated,
+// in ascending order of size. The buffers larger than 8MB are allocated by GC directly, so not counted.
+func AllocatorStats() (stats []SizeClassStats) {
+	for i := range sizeClasses {
+		var class = &sizeClasses[i]
+		if atomic.LoadInt32(&class.used) == 0 {
+			continue
+		}
+		stats = append(stats, SizeClassStats{
+			Size:  1 << i,
+			Count: atomic.LoadInt64(&class.count),
+			Bytes: atomic.LoadInt64(&class.bytes),
+		})
+	}
+	return stats
+}
+
+// mallocMax is 8MB
+const mallocMax = block8k * block1k
+
+// allocator holds allocatorHolder, so that it's safe to replace by SetAllocator concurrently,
+// and the allocators of different types can be stored.
+var allocator atomic.Value
+
+type allocatorHolder struct {
+	Allocator
+}
+
+func init() {
+	allocator.Store(allocatorHolder{mcacheAllocator{}})
+}
+
+// cacheLineSize is used to pad the counters of size classes, which are updated by the pollers concurrently.
+const cacheLineSize = 64
+
+// sizeClasses accounts the memory outstanding, the index is the ceil of log2(cap).
+var sizeClasses [bits.UintSize]struct {
+	count int64
+	bytes int64
+	used  int32
+	_     [cacheLineSize - 20]byte
+}
+
+// malloc limits the cap of the buffer from the allocator.
+func malloc(size, capacity int) []byte {
+	if capacity > mallocMax {
+		return make([]byte, size, capacity)
+	}
+	var buf = allocator.Load().(allocatorHolder).Malloc(size, capacity)
+	account(cap(buf), 1)
+	return buf
+}
+
+// free limits the cap of the buffer from the allocator.
+func free(buf []byte) {
+	if cap(buf) > mallocMax {
+		return
+	}
+	account(cap(buf), -1)
+	allocator.Load().(allocatorHolder).Free(buf)
+}
+
+func account(size int, delta int64) {
+	var i int
+	if size > 1 {
+		i = bits.Len(uint(size - 1))
+	}
+	var class = &sizeClasses[i]
+	if delta > 0 && atomic.LoadInt32(&class.used) == 0 {
+		atomic.StoreInt32(&class.used, 1)
+	}
+	atomic.AddInt64(&class.count, delta)
+	atomic.AddInt64(&class.bytes, delta*int64(size))
+}
+
+type mcacheAllocator struct{}
+
+func (mcacheAllocator) Malloc(size, capacity int) []byte {
+	return mcache.Malloc(size, capacity)
+}
+
+func (mcacheAllocator) Free(buf []byte) {
+	mcache.Free(buf)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+type testAllocator struct {
+	mallocs, frees int32
+}
+
+func (a *testAllocator) Malloc(size, capacity int) []byte {
+	atomic.AddInt32(&a.mallocs, 1)
+	return make([]byte, size, capacity)
+}
+
+func (a *testAllocator) Free(buf []byte) {
+	atomic.AddInt32(&a.frees, 1)
+}
+
+func TestAllocator(t *testing.T) {
+	var stats = func(size int) SizeClassStats {
+		for _, s := range AllocatorStats() {
+			if s.Size == size {
+				return s
+			}
+		}
+		return SizeClassStats{Size: size}
+	}
+	// the size class of 1MB is not used by others
+	var size = 1 << 20
+	var before = stats(size)
+
+	var a = &testAllocator{}
+	SetAllocator(a)
+	defer SetAllocator(nil)
+	var buf = NewLinkBuffer()
+	p, err := buf.Malloc(size - 100)
+	MustNil(t, err)
+	Equal(t, cap(p), size-100)
+	Equal(t, atomic.LoadInt32(&a.mallocs), int32(1))
+	buf.Flush()
+
+	// accounted by the capacity allocated
+	var after = stats(size)
+	Equal(t, after.Count-before.Count, int64(1))
+	Equal(t, after.Bytes-before.Bytes, int64(size-100))
+
+	buf.Skip(buf.Len())
+	buf.Release()
+	buf.Close()
+	Equal(t, atomic.LoadInt32(&a.frees), int32(1))
+	Equal(t, stats(size), before)
+
+	// larger than mallocMax is allocated by GC
+	buf = NewLinkBuffer()
+	buf.Malloc(mallocMax + 1)
+	Equal(t, atomic.LoadInt32(&a.mallocs), int32(1))
+	buf.Close()
+	Equal(t, atomic.LoadInt32(&a.frees), int32(1))
+}
+
+func TestAllocatorSizeClassPadded(t *testing.T) {
+	// the counters of adjacent size classes are not in the same cache line
+	Equal(t, int(unsafe.Sizeof(sizeClasses[0])), cacheLineSize)
+}
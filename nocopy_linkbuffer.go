@@ -18,14 +18,13 @@
 package netpoll
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"unsafe"
-
-	"github.com/bytedance/gopkg/lang/mcache"
 )
 
 // BinaryInplaceThreshold marks the minimum value of the nocopy slice length,
@@ -146,6 +145,50 @@ func (b *LinkBuffer) Peek(n int) (p []byte, err error) {
 	return p, nil
 }
 
+// indexDelim returns the index of the first delim in the readable data without copying, or -1 if not found.
+// The first from bytes have been scanned without delim, so the scanning resumes from there.
+func (b *LinkBuffer) indexDelim(delim []byte, from int) int {
+	var total, keep = b.Len(), len(delim) - 1
+	var base int    // offset of the current node
+	var tail []byte // the last bytes of the previous nodes, used to match across nodes
+	// the delimiter may start in the last keep bytes scanned
+	var start = from - keep
+	for node := b.read; node != nil && base < total; node = node.next {
+		l := node.Len()
+		if base+l > total {
+			l = total - base
+		}
+		if base+l <= start {
+			base += l
+			continue
+		}
+		p := node.Peek(l)
+		if base < start {
+			p, base, l = p[start-base:], start, l-(start-base)
+		}
+		if len(tail) > 0 {
+			n := keep
+			if n > len(p) {
+				n = len(p)
+			}
+			if i := bytes.Index(append(tail, p[:n]...), delim); i >= 0 {
+				return base - len(tail) + i
+			}
+		}
+		if i := bytes.Index(p, delim); i >= 0 {
+			return base + i
+		}
+		base += l
+		if keep > 0 {
+			tail = append(tail, p...)
+			if len(tail) > keep {
+				tail = append(tail[:0], tail[len(tail)-keep:]...)
+			}
+		}
+	}
+	return -1
+}
+
 // Skip implements Reader.
 func (b *LinkBuffer) Skip(n int) (err error) {
 	// check whether enough or not.
@@ -423,6 +466,22 @@ func (b *LinkBuffer) WriteBinary(p []byte) (n int, err error) {
 	return copy(b.write.buf[malloc:b.write.malloc], p), nil
 }
 
+// writeShared appends a read-only node referring to the data of origin without copying,
+// which holds a reference count of origin until the node is released.
+func (b *LinkBuffer) writeShared(origin *linkBufferNode) (n int, err error) {
+	n = len(origin.buf)
+	b.mallocSize += n
+	b.write.next = newLinkBufferNode(0)
+	b.write = b.write.next
+	b.write.buf, b.write.malloc = origin.buf[:0], n
+	b.write.origin = origin
+	atomic.AddInt32(&origin.refer, 1)
+	// an empty tail lets Release drop the shared node once read, instead of holding it until the next write.
+	b.write.next = newLinkBufferNode(0)
+	b.write = b.write.next
+	return n, nil
+}
+
 // WriteDirect cannot be mixed with WriteString or WriteBinary functions.
 func (b *LinkBuffer) WriteDirect(p []byte, remainLen int) error {
 	n := len(p)
@@ -546,6 +605,23 @@ func (b *LinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	return b.write.Malloc(l)
 }
 
+// bookPacket is like book, but guarantees that the returned slice can hold a whole datagram of size bytes,
+// because the bytes exceeding the given buffer will be discarded by datagram sockets.
+//
+// nodeSize: The capacity of the node to be grown, which should not be less than size.
+func (b *LinkBuffer) bookPacket(size, nodeSize int) (p []byte) {
+	if b.write.readonly || cap(b.write.buf)-b.write.malloc < size {
+		b.write.next = newLinkBufferNode(nodeSize)
+		b.write = b.write.next
+
+		// If there is no data in read node, then point it to next one.
+		if b.Len() == 0 {
+			b.read, b.flush = b.write, b.write
+		}
+	}
+	return b.write.Malloc(size)
+}
+
 // bookAck will ack the first n malloc bytes and discard the rest.
 //
 // length: The size of data in inputBuffer. It is used to calculate the maxSize
@@ -612,7 +688,7 @@ func (node *linkBufferNode) Reset() {
 // ------------------------------------------ implement link node ------------------------------------------
 
 // newLinkBufferNode create or reuse linkBufferNode.
-// Nodes with size <= 0 are marked as readonly, which means the node.buf is not allocated by this mcache.
+// Nodes with size <= 0 are marked as readonly, which means the node.buf is not allocated by the allocator.
 func newLinkBufferNode(size int) *linkBufferNode {
 	var node = linkedPool.Get().(*linkBufferNode)
 	if size <= 0 {
@@ -706,7 +782,7 @@ func (node *linkBufferNode) Release() (err error) {
 	// release self
 	if atomic.AddInt32(&node.refer, -1) == 0 {
 		node.off, node.malloc, node.refer, node.origin, node.next = 0, 0, 1, nil, nil
-		// readonly nodes cannot recycle node.buf, other node.buf are recycled to mcache.
+		// readonly nodes cannot recycle node.buf, other node.buf are recycled to the allocator.
 		if node.readonly {
 			node.readonly = false
 		} else {
@@ -750,22 +826,3 @@ func unsafeStringToSlice(s string) (b []byte) {
 	hdr.Len = len(s)
 	return b
 }
-
-// mallocMax is 8MB
-const mallocMax = block8k * block1k
-
-// malloc limits the cap of the buffer from mcache.
-func malloc(size, capacity int) []byte {
-	if capacity > mallocMax {
-		return make([]byte, size, capacity)
-	}
-	return mcache.Malloc(size, capacity)
-}
-
-// free limits the cap of the buffer from mcache.
-func free(buf []byte) {
-	if cap(buf) > mallocMax {
-		return
-	}
-	mcache.Free(buf)
-}
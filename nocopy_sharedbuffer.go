@@ -0,0 +1,76 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errSharedBufferReleased = errors.New("shared buffer has been released")
+
+// SharedBuffer is the read-only data shared by the output of many connections without copying,
+// such as the message broadcast by pub/sub. Its memory is reference-counted, and recycled only after
+// the owner has called Release and every connection appended has sent it or been closed.
+type SharedBuffer struct {
+	node     *linkBufferNode // the origin node holding the data
+	released int32
+}
+
+// NewSharedBuffer copies p into a SharedBuffer, which is owned by the caller until Release.
+func NewSharedBuffer(p []byte) *SharedBuffer {
+	var node = newLinkBufferNode(len(p))
+	node.buf = node.buf[:len(p)]
+	node.malloc = copy(node.buf, p)
+	return &SharedBuffer{node: node}
+}
+
+// Len returns the size of data.
+func (s *SharedBuffer) Len() int {
+	return len(s.node.buf)
+}
+
+// Bytes returns the data, which must not be modified and must not be used after Release.
+func (s *SharedBuffer) Bytes() []byte {
+	return s.node.buf
+}
+
+// AppendTo appends the data to the output of w without copying, which is sent on the next Flush like Append.
+// Writer of Connection and LinkBuffer of netpoll share the data, other Writers get a copy of it.
+// It can be called concurrently for different writers, but not after Release.
+func (s *SharedBuffer) AppendTo(w Writer) (n int, err error) {
+	if atomic.LoadInt32(&s.released) != 0 {
+		return 0, errSharedBufferReleased
+	}
+	switch buf := w.(type) {
+	case *LinkBuffer:
+		return buf.writeShared(s.node)
+	case *connection:
+		return buf.outputBuffer.writeShared(s.node)
+	}
+	p, err := w.Malloc(len(s.node.buf))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, s.node.buf), nil
+}
+
+// Release drops the reference of the owner, the data is recycled once all the connections have released it.
+// It's safe to call Release more than once.
+func (s *SharedBuffer) Release() {
+	if atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		s.node.Release()
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedBuffer(t *testing.T) {
+	var data = make([]byte, 8*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var shared = NewSharedBuffer(data)
+	Equal(t, shared.Len(), len(data))
+	var origin = shared.node
+
+	var bufs = []*LinkBuffer{NewLinkBuffer(), NewLinkBuffer()}
+	for _, buf := range bufs {
+		buf.WriteString("head")
+		n, err := shared.AppendTo(buf)
+		MustNil(t, err)
+		Equal(t, n, len(data))
+		buf.WriteString("tail")
+		buf.Flush()
+	}
+	Equal(t, atomic.LoadInt32(&origin.refer), int32(3))
+	// shared without copying
+	for _, buf := range bufs {
+		var found bool
+		for node := buf.head; node != nil; node = node.next {
+			if node.origin == origin {
+				Equal(t, &node.buf[0], &shared.Bytes()[0])
+				found = true
+			}
+		}
+		MustTrue(t, found)
+	}
+
+	for i, buf := range bufs {
+		MustNil(t, buf.Skip(buf.Len()-len(data)-4))
+		p, err := buf.Next(len(data))
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(p, data))
+		s, _ := buf.ReadString(4)
+		Equal(t, s, "tail")
+		buf.Release()
+		Equal(t, atomic.LoadInt32(&origin.refer), int32(2-i))
+	}
+	shared.Release()
+	shared.Release()
+	_, err := shared.AppendTo(NewLinkBuffer())
+	Equal(t, err, errSharedBufferReleased)
+}
+
+func TestSharedBufferBroadcast(t *testing.T) {
+	var network, address = "tcp", ":8925"
+	var data = bytes.Repeat([]byte("broadcast"), 8*1024)
+	var received = make(chan []byte, 4)
+	var eventLoop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			p, err := connection.Reader().ReadBinary(len(data))
+			if err != nil {
+				return err
+			}
+			received <- p
+			return nil
+		})
+	defer eventLoop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	var shared = NewSharedBuffer(data)
+	var origin = shared.node
+	var conns = make([]Connection, cap(received))
+	for i := range conns {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		defer conn.Close()
+		conns[i] = conn
+		_, err = shared.AppendTo(conn.Writer())
+		MustNil(t, err)
+	}
+	Equal(t, atomic.LoadInt32(&origin.refer), int32(1+len(conns)))
+	for _, conn := range conns {
+		MustNil(t, conn.Writer().Flush())
+	}
+	for range conns {
+		MustTrue(t, bytes.Equal(<-received, data))
+	}
+	// the data is released by every connection once sent
+	var deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt32(&origin.refer) > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("refer=%d, the shared data is not released", atomic.LoadInt32(&origin.refer))
+		}
+		runtime.Gosched()
+	}
+	shared.Release()
+}
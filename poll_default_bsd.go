@@ -97,7 +97,7 @@ func (p *defaultPoll) Wait() error {
 				if len(bs) == 0 {
 					break
 				}
-				var n, err = readv(operator.FD, bs, barriers[i].ivs)
+				var n, err = operator.readv(bs, barriers[i].ivs)
 				operator.InputAck(n)
 				if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 					log.Printf("readv(fd=%d) failed: %s", operator.FD, err.Error())
@@ -146,7 +146,7 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 					// for connection
 					var bs = operator.Inputs(p.barriers[i].bs)
 					if len(bs) > 0 {
-						var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+						var n, err = operator.readv(bs, p.barriers[i].ivs)
 						operator.InputAck(n)
 						if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 							log.Printf("readv(fd=%d) failed: %s", operator.FD, err.Error())
@@ -117,3 +117,13 @@ func (m *manager) Reset() error {
 func (m *manager) Pick() Poll {
 	return m.balance.Pick()
 }
+
+// index returns the index of poll in all the pollers, or -1 if not found.
+func (m *manager) index(poll Poll) int {
+	for i, p := range m.polls {
+		if p == poll {
+			return i
+		}
+	}
+	return -1
+}
@@ -104,7 +104,7 @@ func (p *defaultPoll) Wait() error {
 				if len(bs) == 0 {
 					break
 				}
-				var n, err = readv(operator.FD, bs, barriers[i].ivs)
+				var n, err = operator.readv(bs, barriers[i].ivs)
 				operator.InputAck(n)
 				if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 					hups = append(hups, operator)
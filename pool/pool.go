@@ -0,0 +1,502 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Package pool provides the client-side connection pool of netpoll.Connection, keyed by the dialed address.
+ *
+ * Get: reuse an idle connection of the address, or dial a new one, and wait for the capacity if MaxActive is reached.
+ * Conn.Close: put the connection back into the pool, while Conn.Discard closes it really.
+ *
+ * The idle connections are closed and removed as soon as the poller sees a hangup by OnDisconnect,
+ * and the accounting is driven by the close callbacks, rather than checked on next use.
+ */
+
+// ErrPoolClosed is returned by Get when the pool has been closed.
+var ErrPoolClosed = errors.New("connection pool has been closed")
+
+// errPoolRemoved is returned by the keyed pool removed from Pool, and Get retries with a new one.
+var errPoolRemoved = errors.New("keyed pool has been removed")
+
+// Option .
+type Option struct {
+	f func(*options)
+}
+
+type options struct {
+	dialer      netpoll.Dialer
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+}
+
+// WithDialer sets the dialer used to dial new connections, netpoll.NewDialer() by default.
+func WithDialer(dialer netpoll.Dialer) Option {
+	return Option{func(op *options) {
+		op.dialer = dialer
+	}}
+}
+
+// WithMaxIdle sets the maximum number of idle connections of each address, 0 means no idle connection is kept.
+func WithMaxIdle(maxIdle int) Option {
+	return Option{func(op *options) {
+		op.maxIdle = maxIdle
+	}}
+}
+
+// WithMaxActive sets the maximum number of connections of each address, including the idle ones.
+// 0 means no limit.
+func WithMaxActive(maxActive int) Option {
+	return Option{func(op *options) {
+		op.maxActive = maxActive
+	}}
+}
+
+// WithIdleTimeout sets the time after which the idle connections are closed, 0 means never.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.idleTimeout = timeout
+	}}
+}
+
+// Stats is the statistics of connections to an address.
+type Stats struct {
+	Active int // connections opened, including idle
+	Idle   int // connections idle in the pool
+}
+
+// NewPool creates a connection pool. By default, it keeps at most 8 idle connections of each address without
+// limiting the active ones.
+func NewPool(opts ...Option) *Pool {
+	p := &Pool{
+		pools: make(map[string]*keyedPool),
+		done:  make(chan struct{}),
+	}
+	p.opts.maxIdle = 8
+	for _, do := range opts {
+		do.f(&p.opts)
+	}
+	if p.opts.dialer == nil {
+		p.opts.dialer = netpoll.NewDialer()
+	}
+	if p.opts.idleTimeout > 0 {
+		go p.evict()
+	}
+	return p
+}
+
+// Pool is the connection pool keyed by network and address. It's safe for concurrent use.
+type Pool struct {
+	opts   options
+	mu     sync.Mutex
+	pools  map[string]*keyedPool
+	closed bool
+	done   chan struct{}
+}
+
+// Get returns an idle connection to the address, or dials a new one. If the number of connections reaches
+// MaxActive, it waits until one is put back or closed, or ctx is done.
+func (p *Pool) Get(ctx context.Context, network, address string) (*Conn, error) {
+	for {
+		kp, err := p.keyed(network, address)
+		if err != nil {
+			return nil, err
+		}
+		c, err := kp.get(ctx)
+		// the keyed pool has been removed after getting it, try the new one
+		if err != errPoolRemoved {
+			return c, err
+		}
+	}
+}
+
+// Stats returns the statistics of connections to the address.
+func (p *Pool) Stats(network, address string) Stats {
+	p.mu.Lock()
+	kp := p.pools[key(network, address)]
+	p.mu.Unlock()
+	if kp == nil {
+		return Stats{}
+	}
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return Stats{Active: kp.active, Idle: len(kp.idle)}
+}
+
+// Close closes all the idle connections, and the connections in use are closed when put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var pools = make([]*keyedPool, 0, len(p.pools))
+	for _, kp := range p.pools {
+		pools = append(pools, kp)
+	}
+	p.mu.Unlock()
+
+	for _, kp := range pools {
+		kp.close()
+	}
+	return nil
+}
+
+func (p *Pool) keyed(network, address string) (*keyedPool, error) {
+	k := key(network, address)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	kp := p.pools[k]
+	if kp == nil {
+		kp = &keyedPool{pool: p, opts: &p.opts, network: network, address: address}
+		p.pools[k] = kp
+	}
+	return kp, nil
+}
+
+// evict closes the expired idle connections periodically.
+func (p *Pool) evict() {
+	interval := p.opts.idleTimeout / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			var pools = make([]*keyedPool, 0, len(p.pools))
+			for _, kp := range p.pools {
+				pools = append(pools, kp)
+			}
+			p.mu.Unlock()
+			for _, kp := range pools {
+				kp.evict(now.Add(-p.opts.idleTimeout))
+			}
+		}
+	}
+}
+
+// release removes the keyed pool once it has no connection or waiter, so the keys of the addresses
+// no longer used don't accumulate.
+func (p *Pool) release(kp *keyedPool) {
+	k := key(kp.network, kp.address)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.removed || kp.active > 0 || len(kp.waiters) > 0 || p.pools[k] != kp {
+		return
+	}
+	kp.removed = true
+	delete(p.pools, k)
+}
+
+func key(network, address string) string {
+	return network + "://" + address
+}
+
+// keyedPool is the pool of connections to one address.
+type keyedPool struct {
+	pool             *Pool
+	opts             *options
+	network, address string
+
+	mu      sync.Mutex
+	idle    []*Conn // the latest put back is at the tail
+	active  int
+	waiters []chan *Conn // a nil *Conn means a slot is released
+	closed  bool
+	removed bool // removed from Pool since it's empty
+}
+
+func (kp *keyedPool) get(ctx context.Context) (*Conn, error) {
+	for {
+		kp.mu.Lock()
+		if kp.closed {
+			kp.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if kp.removed {
+			kp.mu.Unlock()
+			return nil, errPoolRemoved
+		}
+		// reuse the latest idle connection, the closed ones have been removed by close callback
+		if n := len(kp.idle); n > 0 {
+			c := kp.idle[n-1]
+			kp.idle[n-1] = nil
+			kp.idle = kp.idle[:n-1]
+			kp.mu.Unlock()
+			if c.IsActive() {
+				atomic.StoreInt32(&c.released, 0)
+				return c, nil
+			}
+			// disconnected after popping
+			c.Connection.Close()
+			continue
+		}
+		if kp.opts.maxActive <= 0 || kp.active < kp.opts.maxActive {
+			kp.active++
+			kp.mu.Unlock()
+			return kp.dial(ctx)
+		}
+		// wait for the capacity
+		ch := make(chan *Conn, 1)
+		kp.waiters = append(kp.waiters, ch)
+		kp.mu.Unlock()
+
+		select {
+		case c := <-ch:
+			if c != nil {
+				return c, nil
+			}
+		case <-ctx.Done():
+			kp.mu.Lock()
+			for i := range kp.waiters {
+				if kp.waiters[i] == ch {
+					kp.waiters = append(kp.waiters[:i], kp.waiters[i+1:]...)
+					break
+				}
+			}
+			kp.mu.Unlock()
+			// the notification may be sent before removing, so pass it on
+			select {
+			case c := <-ch:
+				if c != nil {
+					c.Close()
+				} else {
+					kp.mu.Lock()
+					kp.notify(nil)
+					kp.mu.Unlock()
+				}
+			default:
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// dial dials a new connection, and the slot has been taken.
+func (kp *keyedPool) dial(ctx context.Context) (*Conn, error) {
+	conn, err := dialContext(ctx, kp.opts.dialer, kp.network, kp.address)
+	if err != nil {
+		kp.mu.Lock()
+		kp.active--
+		kp.notify(nil)
+		var empty = kp.active == 0
+		kp.mu.Unlock()
+		if empty {
+			kp.pool.release(kp)
+		}
+		return nil, err
+	}
+	c := &Conn{Connection: conn, pool: kp}
+	conn.AddCloseCallback(c.onClose)
+	if notifier, ok := conn.(netpoll.DisconnectNotifier); ok {
+		notifier.SetOnDisconnect(c.onDisconnect)
+	}
+	// the callbacks may have been called before adding
+	if !conn.IsActive() {
+		c.onClose(conn)
+		return nil, netpoll.Exception(netpoll.ErrConnClosed, "after dialing")
+	}
+	return c, nil
+}
+
+// dialContext dials by DialContext if dialer implements netpoll.ContextDialer,
+// otherwise the deadline of ctx is used as the timeout.
+func dialContext(ctx context.Context, dialer netpoll.Dialer, network, address string) (netpoll.Connection, error) {
+	if cd, ok := dialer.(netpoll.ContextDialer); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return dialer.DialConnection(network, address, timeout)
+}
+
+// put puts c back into the pool, or closes it if it can't be reused.
+func (kp *keyedPool) put(c *Conn) error {
+	// run the close callbacks of the disconnected one
+	if !c.IsActive() {
+		return c.Connection.Close()
+	}
+	// the unread data makes the connection unusable for the next user
+	if c.Reader().Len() > 0 {
+		return c.Connection.Close()
+	}
+	kp.mu.Lock()
+	if kp.closed {
+		kp.mu.Unlock()
+		return c.Connection.Close()
+	}
+	// hand over to the waiter directly
+	if len(kp.waiters) > 0 {
+		atomic.StoreInt32(&c.released, 0)
+		kp.notify(c)
+		kp.mu.Unlock()
+		return nil
+	}
+	if len(kp.idle) >= kp.opts.maxIdle {
+		kp.mu.Unlock()
+		return c.Connection.Close()
+	}
+	c.lastUsed = time.Now()
+	kp.idle = append(kp.idle, c)
+	// OnDisconnect may have been called before it's idle
+	if !c.IsActive() {
+		kp.idle = kp.idle[:len(kp.idle)-1]
+		kp.mu.Unlock()
+		return c.Connection.Close()
+	}
+	kp.mu.Unlock()
+	return nil
+}
+
+// remove is called when the connection is closed.
+func (kp *keyedPool) remove(c *Conn) {
+	kp.mu.Lock()
+	for i := range kp.idle {
+		if kp.idle[i] == c {
+			kp.idle = append(kp.idle[:i], kp.idle[i+1:]...)
+			break
+		}
+	}
+	kp.active--
+	kp.notify(nil)
+	var empty = kp.active == 0
+	kp.mu.Unlock()
+	if empty {
+		kp.pool.release(kp)
+	}
+}
+
+// notify wakes up the first waiter, the lock must be held.
+func (kp *keyedPool) notify(c *Conn) {
+	if len(kp.waiters) == 0 {
+		return
+	}
+	ch := kp.waiters[0]
+	kp.waiters = kp.waiters[1:]
+	ch <- c
+}
+
+// evict closes the idle connections which are put back before deadline.
+func (kp *keyedPool) evict(deadline time.Time) {
+	kp.mu.Lock()
+	var n int
+	for n < len(kp.idle) && kp.idle[n].lastUsed.Before(deadline) {
+		n++
+	}
+	var expired = make([]*Conn, n)
+	copy(expired, kp.idle[:n])
+	kp.idle = append(kp.idle[:0], kp.idle[n:]...)
+	kp.mu.Unlock()
+
+	for _, c := range expired {
+		c.Connection.Close()
+	}
+}
+
+func (kp *keyedPool) close() {
+	kp.mu.Lock()
+	kp.closed = true
+	var idle = kp.idle
+	kp.idle = nil
+	var waiters = kp.waiters
+	kp.waiters = nil
+	kp.mu.Unlock()
+
+	for _, ch := range waiters {
+		ch <- nil
+	}
+	for _, c := range idle {
+		c.Connection.Close()
+	}
+}
+
+// Conn is the connection got from Pool.
+// Close puts it back into the pool, and Discard closes it actually.
+type Conn struct {
+	netpoll.Connection
+	pool     *keyedPool
+	lastUsed time.Time
+	released int32
+	removed  int32
+}
+
+// Close puts the connection back into the pool, which is closed if it has unread data,
+// or the idle connections exceed MaxIdle. It's no-op when called repeatedly.
+func (c *Conn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.released, 0, 1) {
+		return nil
+	}
+	return c.pool.put(c)
+}
+
+// Discard closes the connection instead of putting it back, it's used when the connection is broken.
+func (c *Conn) Discard() error {
+	atomic.StoreInt32(&c.released, 1)
+	return c.Connection.Close()
+}
+
+// onDisconnect closes the idle connection once the poller finds it's disconnected,
+// and the one in use is closed when put back.
+func (c *Conn) onDisconnect(connection netpoll.Connection) {
+	c.pool.mu.Lock()
+	var idle bool
+	for _, ic := range c.pool.idle {
+		if ic == c {
+			idle = true
+			break
+		}
+	}
+	c.pool.mu.Unlock()
+	if idle {
+		c.Connection.Close()
+	}
+}
+
+// onClose is the close callback of the underlying connection.
+func (c *Conn) onClose(connection netpoll.Connection) error {
+	if atomic.CompareAndSwapInt32(&c.removed, 0, 1) {
+		c.pool.remove(c)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+// newTestServer serves an echo server, which closes the connection when receiving "bye".
+func newTestServer(t *testing.T, address string) {
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		reader := connection.Reader()
+		buf, err := reader.Next(reader.Len())
+		if err != nil {
+			return err
+		}
+		if string(buf) == "bye" {
+			return connection.Close()
+		}
+		connection.Writer().WriteBinary(buf)
+		return connection.Writer().Flush()
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		loop.Shutdown(ctx)
+	})
+}
+
+func ping(t *testing.T, conn netpoll.Connection) {
+	t.Helper()
+	_, err := conn.Writer().WriteString("ping")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	conn.Reader().Release()
+}
+
+// waitStats waits until the stats of address equals expect.
+func waitStats(t *testing.T, p *Pool, address string, expect Stats) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if p.Stats("tcp", address) == expect {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stats=%+v, expect=%+v", p.Stats("tcp", address), expect)
+}
+
+// waitRemoved waits until the empty keyed pool of address is removed.
+func waitRemoved(t *testing.T, p *Pool, address string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		p.mu.Lock()
+		var kp = p.pools[key("tcp", address)]
+		p.mu.Unlock()
+		if kp == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("keyed pool of %s is not removed", address)
+}
+
+func TestPoolReuse(t *testing.T) {
+	var address = "127.0.0.1:8904"
+	newTestServer(t, address)
+	p := NewPool(WithMaxIdle(1))
+	defer p.Close()
+
+	c1, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	ping(t, c1)
+	c2, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	ping(t, c2)
+	Equal(t, p.Stats("tcp", address), Stats{Active: 2})
+
+	MustNil(t, c1.Close())
+	MustNil(t, c1.Close()) // no-op
+	Equal(t, p.Stats("tcp", address), Stats{Active: 2, Idle: 1})
+	// exceed max idle
+	MustNil(t, c2.Close())
+	waitStats(t, p, address, Stats{Active: 1, Idle: 1})
+
+	c3, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	MustTrue(t, c3.Connection == c1.Connection)
+	ping(t, c3)
+	MustNil(t, c3.Discard())
+	waitStats(t, p, address, Stats{})
+}
+
+func TestPoolMaxActive(t *testing.T) {
+	var address = "127.0.0.1:8905"
+	newTestServer(t, address)
+	p := NewPool(WithMaxActive(1))
+	defer p.Close()
+
+	c1, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+
+	// timeout when waiting for capacity
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx, "tcp", address)
+	Equal(t, err, context.DeadlineExceeded)
+
+	// handed over when put back
+	var got = make(chan *Conn, 1)
+	go func() {
+		c, err := p.Get(context.Background(), "tcp", address)
+		MustNil(t, err)
+		got <- c
+	}()
+	time.Sleep(10 * time.Millisecond)
+	MustNil(t, c1.Close())
+	c2 := <-got
+	MustTrue(t, c2.Connection == c1.Connection)
+	ping(t, c2)
+
+	// a new one is dialed when closed
+	go func() {
+		c, err := p.Get(context.Background(), "tcp", address)
+		MustNil(t, err)
+		got <- c
+	}()
+	time.Sleep(10 * time.Millisecond)
+	MustNil(t, c2.Discard())
+	c3 := <-got
+	MustTrue(t, c3.Connection != c2.Connection)
+	ping(t, c3)
+	c3.Close()
+	Equal(t, p.Stats("tcp", address), Stats{Active: 1, Idle: 1})
+
+	// wake up the waiters when closing pool
+	c4, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	var errs = make(chan error, 1)
+	go func() {
+		_, err := p.Get(context.Background(), "tcp", address)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	MustNil(t, p.Close())
+	Equal(t, <-errs, ErrPoolClosed)
+	MustNil(t, c4.Close())
+	MustTrue(t, !c4.IsActive())
+	_, err = p.Get(context.Background(), "tcp", address)
+	Equal(t, err, ErrPoolClosed)
+}
+
+func TestPoolHangup(t *testing.T) {
+	var address = "127.0.0.1:8906"
+	newTestServer(t, address)
+	p := NewPool()
+	defer p.Close()
+
+	c, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	ping(t, c)
+	// the server closes the idle connection
+	_, err = c.Writer().WriteString("bye")
+	MustNil(t, err)
+	MustNil(t, c.Writer().Flush())
+	MustNil(t, c.Close())
+	waitStats(t, p, address, Stats{})
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	var address = "127.0.0.1:8907"
+	newTestServer(t, address)
+	p := NewPool(WithIdleTimeout(50 * time.Millisecond))
+	defer p.Close()
+
+	c, err := p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	ping(t, c)
+	MustNil(t, c.Close())
+	Equal(t, p.Stats("tcp", address), Stats{Active: 1, Idle: 1})
+	waitStats(t, p, address, Stats{})
+	// the empty keyed pool is removed, and a new one is created when getting again
+	waitRemoved(t, p, address)
+	c, err = p.Get(context.Background(), "tcp", address)
+	MustNil(t, err)
+	ping(t, c)
+	Equal(t, p.Stats("tcp", address), Stats{Active: 1})
+	MustNil(t, c.Discard())
+	waitRemoved(t, p, address)
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"syscall"
+)
+
+// batchIO falls back to read and write datagrams one by one, since recvmmsg and sendmmsg are not supported.
+type batchIO struct{}
+
+func newBatchIO(size int) *batchIO {
+	return &batchIO{}
+}
+
+// recv reads at most len(msgs) datagrams by recvfrom.
+func (b *batchIO) recv(fd int, msgs []packetMsg, gro bool) (n int, err error) {
+	for n = 0; n < len(msgs); n++ {
+		var m, sa, err = syscall.Recvfrom(fd, msgs[n].buf, 0)
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		msgs[n].n, msgs[n].addr, msgs[n].segment = m, sockaddrToUDPAddr(sa), 0
+	}
+	return n, nil
+}
+
+// send writes msgs by sendmsg, and returns the number of datagrams sent.
+func (b *batchIO) send(fd int, msgs []packetMsg) (n int, err error) {
+	for n = 0; n < len(msgs); n++ {
+		var m, err = sendmsgto(fd, msgs[n].bs, msgs[n].ivs, msgs[n].to)
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		msgs[n].n = m
+	}
+	return n, nil
+}
+
+func enableUDPGRO(fd int) bool {
+	return false
+}
+
+func supportUDPGSO(fd int) bool {
+	return false
+}
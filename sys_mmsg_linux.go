@@ -0,0 +1,166 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// socket options of UDP GSO/GRO, which are not defined by syscall package.
+const (
+	solUDP     = 0x11
+	udpSegment = 0x67
+	udpGRO     = 0x68
+)
+
+// batchIO holds the memory used by recvmmsg and sendmmsg, which is not concurrency safe.
+type batchIO struct {
+	hdrs  []mmsghdr
+	names []syscall.RawSockaddrAny
+	ivs   []syscall.Iovec
+	oobs  []byte
+}
+
+var cmsgSegmentSpace = syscall.CmsgSpace(4)
+
+func newBatchIO(size int) *batchIO {
+	return &batchIO{
+		hdrs:  make([]mmsghdr, size),
+		names: make([]syscall.RawSockaddrAny, size),
+		ivs:   make([]syscall.Iovec, size),
+		oobs:  make([]byte, size*cmsgSegmentSpace),
+	}
+}
+
+// recv reads at most len(msgs) datagrams by recvmmsg.
+func (b *batchIO) recv(fd int, msgs []packetMsg, gro bool) (n int, err error) {
+	for i := range msgs {
+		var hdr = &b.hdrs[i].hdr
+		*hdr = syscall.Msghdr{}
+		b.ivs[i] = syscall.Iovec{Base: &msgs[i].buf[0]}
+		b.ivs[i].SetLen(len(msgs[i].buf))
+		hdr.Iov, hdr.Iovlen = &b.ivs[i], 1
+		hdr.Name, hdr.Namelen = (*byte)(unsafe.Pointer(&b.names[i])), syscall.SizeofSockaddrAny
+		if gro {
+			hdr.Control = &b.oobs[i*cmsgSegmentSpace]
+			hdr.SetControllen(cmsgSegmentSpace)
+		}
+	}
+	r, _, e := syscall.RawSyscall6(syscall.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(len(msgs)), 0, 0, 0)
+	if e != 0 {
+		return 0, syscall.Errno(e)
+	}
+	n = int(r)
+	for i := 0; i < n; i++ {
+		msgs[i].n = int(b.hdrs[i].len)
+		msgs[i].addr = rawToUDPAddr(&b.names[i])
+		msgs[i].segment = 0
+		if gro && b.hdrs[i].hdr.Controllen > 0 {
+			msgs[i].segment = parseSegment(b.oobs[i*cmsgSegmentSpace : i*cmsgSegmentSpace+int(b.hdrs[i].hdr.Controllen)])
+		}
+	}
+	return n, nil
+}
+
+// send writes msgs by sendmmsg, and returns the number of datagrams sent.
+func (b *batchIO) send(fd int, msgs []packetMsg) (n int, err error) {
+	for i := range msgs {
+		var hdr = &b.hdrs[i].hdr
+		*hdr = syscall.Msghdr{}
+		iovLen := iovecs(msgs[i].bs, msgs[i].ivs)
+		if iovLen > 0 {
+			hdr.Iov, hdr.Iovlen = &msgs[i].ivs[0], uint64(iovLen)
+		}
+		name, namelen, err := rawSockaddr(msgs[i].to)
+		if err != nil {
+			return 0, err
+		}
+		hdr.Name, hdr.Namelen = (*byte)(name), namelen
+		if msgs[i].segment > 0 {
+			var oob = b.oobs[i*cmsgSegmentSpace : (i+1)*cmsgSegmentSpace]
+			putSegment(oob, msgs[i].segment)
+			hdr.Control = &oob[0]
+			hdr.SetControllen(syscall.CmsgSpace(2))
+		}
+	}
+	r, _, e := syscall.RawSyscall6(_SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(len(msgs)), 0, 0, 0)
+	if e != 0 {
+		return 0, syscall.Errno(e)
+	}
+	n = int(r)
+	for i := 0; i < n; i++ {
+		msgs[i].n = int(b.hdrs[i].len)
+	}
+	return n, nil
+}
+
+// putSegment writes the UDP_SEGMENT control message.
+func putSegment(oob []byte, segment int) {
+	var h = (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = solUDP, udpSegment
+	h.SetLen(syscall.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = uint16(segment)
+}
+
+// parseSegment reads the segment size from the UDP_GRO control message.
+func parseSegment(oob []byte) (segment int) {
+	for len(oob) >= syscall.CmsgLen(0) {
+		var h = (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		if int(h.Len) < syscall.CmsgLen(0) || int(h.Len) > len(oob) {
+			return 0
+		}
+		if h.Level == solUDP && h.Type == udpGRO && int(h.Len) >= syscall.CmsgLen(4) {
+			return int(*(*int32)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])))
+		}
+		var space = syscall.CmsgSpace(int(h.Len) - syscall.CmsgLen(0))
+		if space > len(oob) {
+			return 0
+		}
+		oob = oob[space:]
+	}
+	return 0
+}
+
+// rawToUDPAddr converts the raw address filled by kernel to the UDP address.
+func rawToUDPAddr(raw *syscall.RawSockaddrAny) net.Addr {
+	switch raw.Addr.Family {
+	case syscall.AF_INET:
+		var pp = (*syscall.RawSockaddrInet4)(unsafe.Pointer(raw))
+		var p = (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &net.UDPAddr{
+			IP:   append(net.IP{}, pp.Addr[:]...),
+			Port: int(p[0])<<8 + int(p[1]),
+		}
+	case syscall.AF_INET6:
+		var pp = (*syscall.RawSockaddrInet6)(unsafe.Pointer(raw))
+		var p = (*[2]byte)(unsafe.Pointer(&pp.Port))
+		var sa = &syscall.SockaddrInet6{Port: int(p[0])<<8 + int(p[1]), ZoneId: pp.Scope_id, Addr: pp.Addr}
+		return sockaddrToUDPAddr(sa)
+	}
+	return nil
+}
+
+// enableUDPGRO enables UDP generic receive offload, which is supported since linux 5.0.
+func enableUDPGRO(fd int) bool {
+	return syscall.SetsockoptInt(fd, solUDP, udpGRO, 1) == nil
+}
+
+// supportUDPGSO checks whether UDP generic segmentation offload is supported, which is supported since linux 4.18.
+func supportUDPGSO(fd int) bool {
+	_, err := syscall.GetsockoptInt(fd, solUDP, udpSegment)
+	return err == nil
+}
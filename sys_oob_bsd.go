@@ -0,0 +1,90 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// recvmsg wraps the recvmsg system call, which reads the ancillary data into oob.
+// The received file descriptors are close-on-exec.
+func recvmsg(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n, oobn int, err error) {
+	iovLen := iovecs(bs, ivs)
+	if iovLen == 0 {
+		return 0, 0, nil
+	}
+	var msghdr = syscall.Msghdr{
+		Iov:    &ivs[0],
+		Iovlen: int32(iovLen),
+	}
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_RECVMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	if e != 0 {
+		return int(r), 0, syscall.Errno(e)
+	}
+	return int(r), int(msghdr.Controllen), nil
+}
+
+// sendmsgoob wraps the sendmsg system call with the ancillary data.
+func sendmsgoob(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n int, err error) {
+	iovLen := iovecs(bs, ivs)
+	var msghdr = syscall.Msghdr{
+		Iovlen: int32(iovLen),
+	}
+	if iovLen > 0 {
+		msghdr.Iov = &ivs[0]
+	}
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	if e != 0 {
+		return int(r), syscall.Errno(e)
+	}
+	return int(r), nil
+}
+
+// parseOOB parses the file descriptors from the ancillary data,
+// credentials are not supported by SCM_CREDENTIALS on BSD.
+func parseOOB(oob []byte) (fds []int, cred *UnixCredentials) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, nil
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET || msgs[i].Header.Type != syscall.SCM_RIGHTS {
+			continue
+		}
+		rights, _ := syscall.ParseUnixRights(&msgs[i])
+		for _, fd := range rights {
+			syscall.CloseOnExec(fd)
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+// setPassCred is not supported on BSD.
+func setPassCred(fd int, enable bool) error {
+	return Exception(ErrUnsupported, "SO_PASSCRED")
+}
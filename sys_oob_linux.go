@@ -0,0 +1,90 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// recvmsg wraps the recvmsg system call, which reads the ancillary data into oob.
+// The received file descriptors are close-on-exec.
+func recvmsg(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n, oobn int, err error) {
+	iovLen := iovecs(bs, ivs)
+	if iovLen == 0 {
+		return 0, 0, nil
+	}
+	var msghdr = syscall.Msghdr{
+		Iov:    &ivs[0],
+		Iovlen: uint64(iovLen),
+	}
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_RECVMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), syscall.MSG_CMSG_CLOEXEC)
+	if e != 0 {
+		return int(r), 0, syscall.Errno(e)
+	}
+	return int(r), int(msghdr.Controllen), nil
+}
+
+// sendmsgoob wraps the sendmsg system call with the ancillary data.
+func sendmsgoob(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n int, err error) {
+	iovLen := iovecs(bs, ivs)
+	var msghdr = syscall.Msghdr{
+		Iovlen: uint64(iovLen),
+	}
+	if iovLen > 0 {
+		msghdr.Iov = &ivs[0]
+	}
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	if e != 0 {
+		return int(r), syscall.Errno(e)
+	}
+	return int(r), nil
+}
+
+// parseOOB parses the file descriptors and credentials from the ancillary data.
+func parseOOB(oob []byte) (fds []int, cred *UnixCredentials) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, nil
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET {
+			continue
+		}
+		switch msgs[i].Header.Type {
+		case syscall.SCM_RIGHTS:
+			rights, _ := syscall.ParseUnixRights(&msgs[i])
+			fds = append(fds, rights...)
+		case syscall.SCM_CREDENTIALS:
+			if ucred, err := syscall.ParseUnixCredentials(&msgs[i]); err == nil {
+				cred = &UnixCredentials{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}
+			}
+		}
+	}
+	return fds, cred
+}
+
+// setPassCred enables or disables receiving SCM_CREDENTIALS.
+func setPassCred(fd int, enable bool) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_PASSCRED, boolint(enable))
+}
@@ -50,3 +50,46 @@ func sendmsg(fd int, bs [][]byte, ivs []syscall.Iovec, zerocopy bool) (n int, er
 	}
 	return int(r), nil
 }
+
+// sendmsgto wraps the sendmsg system call with the destination address,
+// which is used by datagram sockets to send bs as a single datagram.
+func sendmsgto(fd int, bs [][]byte, ivs []syscall.Iovec, to syscall.Sockaddr) (n int, err error) {
+	iovLen := iovecs(bs, ivs)
+	name, namelen, err := rawSockaddr(to)
+	if err != nil {
+		return 0, err
+	}
+	var msghdr = syscall.Msghdr{
+		Name:    (*byte)(name),
+		Namelen: namelen,
+		Iovlen:  uint64(iovLen),
+	}
+	if iovLen > 0 {
+		msghdr.Iov = &ivs[0]
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	if e != 0 {
+		return int(r), syscall.Errno(e)
+	}
+	return int(r), nil
+}
+
+// rawSockaddr converts syscall.Sockaddr to the raw format used by the kernel.
+func rawSockaddr(sa syscall.Sockaddr) (ptr unsafe.Pointer, l uint32, err error) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		var raw = &syscall.RawSockaddrInet4{Family: syscall.AF_INET}
+		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		raw.Addr = sa.Addr
+		return unsafe.Pointer(raw), syscall.SizeofSockaddrInet4, nil
+	case *syscall.SockaddrInet6:
+		var raw = &syscall.RawSockaddrInet6{Family: syscall.AF_INET6}
+		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		raw.Scope_id = sa.ZoneId
+		raw.Addr = sa.Addr
+		return unsafe.Pointer(raw), syscall.SizeofSockaddrInet6, nil
+	}
+	return nil, 0, syscall.EAFNOSUPPORT
+}
@@ -0,0 +1,411 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	// recordHeaderLen is the length of TLS record header.
+	recordHeaderLen = 5
+	// maxPlaintext is the maximum plaintext payload length of a TLS record.
+	maxPlaintext = 16 * 1024
+)
+
+// Conn is a TLS connection over netpoll.Connection, which implements netpoll.Connection.
+//
+// The records are decrypted from the input buffer of the underlying connection into a plaintext LinkBuffer,
+// which is read through the nocopy Reader, and the plaintext written by Writer is encrypted on Flush.
+// Like netpoll.Connection, it does not support reading or writing by multiple goroutines.
+type Conn struct {
+	netpoll.Connection
+	raw     *rawConn
+	conn    *tls.Conn
+	input   *netpoll.LinkBuffer // plaintext decrypted
+	output  *netpoll.LinkBuffer // plaintext to be encrypted
+	process atomic.Value        // value is netpoll.OnRequest
+}
+
+var _ netpoll.Connection = &Conn{}
+var _ netpoll.Reader = &Conn{}
+var _ netpoll.Writer = &Conn{}
+
+// Server returns a server-side TLS connection over conn, the handshake runs when ClientHello arrives.
+func Server(conn netpoll.Connection, config *tls.Config) *Conn {
+	var c = newConn(conn)
+	c.conn = tls.Server(c.raw, config)
+	return c
+}
+
+// Client returns a client-side TLS connection over conn, the handshake runs on the first read or write,
+// or calling Handshake explicitly.
+func Client(conn netpoll.Connection, config *tls.Config) *Conn {
+	var c = newConn(conn)
+	c.conn = tls.Client(c.raw, config)
+	return c
+}
+
+func newConn(conn netpoll.Connection) *Conn {
+	return &Conn{
+		Connection: conn,
+		raw:        &rawConn{Connection: conn},
+		input:      netpoll.NewLinkBuffer(),
+		output:     netpoll.NewLinkBuffer(),
+	}
+}
+
+// Dial connects to the address and runs the handshake within timeout.
+func Dial(network, address string, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	conn, err := netpoll.DialConnection(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var c = Client(conn, config)
+	conn.SetReadTimeout(timeout)
+	if err = c.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadTimeout(0)
+	return c, nil
+}
+
+// Handshake runs the TLS handshake if it has not yet been run.
+func (c *Conn) Handshake() error {
+	return c.conn.Handshake()
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState()
+}
+
+// Reader implements netpoll.Connection.
+func (c *Conn) Reader() netpoll.Reader {
+	return c
+}
+
+// Writer implements netpoll.Connection.
+func (c *Conn) Writer() netpoll.Writer {
+	return c
+}
+
+// SetOnRequest implements netpoll.Connection, the handler is called with the plaintext available.
+func (c *Conn) SetOnRequest(on netpoll.OnRequest) error {
+	if on == nil {
+		return nil
+	}
+	c.process.Store(on)
+	return c.Connection.SetOnRequest(c.onRequest)
+}
+
+// AddCloseCallback implements netpoll.Connection.
+func (c *Conn) AddCloseCallback(callback netpoll.CloseCallback) error {
+	return c.Connection.AddCloseCallback(func(netpoll.Connection) error {
+		return callback(c)
+	})
+}
+
+// Close implements netpoll.Connection, which sends close_notify before closing.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// onRequest decrypts the records received, and then calls the handler with plaintext.
+// The partial record is kept by tls.Conn, and continued by the next onRequest when more data arrives.
+func (c *Conn) onRequest(ctx context.Context, _ netpoll.Connection) error {
+	// The handshake blocks until finished, which is allowed in OnRequest.
+	if err := c.conn.Handshake(); err != nil {
+		c.Connection.Close()
+		return err
+	}
+	if err := c.decrypt(); err != nil {
+		c.Close()
+		return err
+	}
+	var handler = c.process.Load().(netpoll.OnRequest)
+	for c.input.Len() > 0 && c.IsActive() {
+		handler(ctx, c)
+	}
+	return nil
+}
+
+// decrypt decrypts the records received without waiting for the partial record.
+func (c *Conn) decrypt() error {
+	c.raw.nonblock = true
+	defer func() { c.raw.nonblock = false }()
+	for c.raw.Reader().Len() > 0 {
+		if err := c.fill(); err == errWouldBlock {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fill decrypts the next record into input, which blocks until a record is received,
+// or returns errWouldBlock if the record is not received completely in nonblocking mode.
+func (c *Conn) fill() error {
+	var buf, _ = c.input.Malloc(maxPlaintext)
+	var n, err = c.conn.Read(buf)
+	c.input.MallocAck(n)
+	c.input.Flush()
+	return err
+}
+
+// waitRead decrypts the records until n bytes plaintext are available.
+func (c *Conn) waitRead(n int) error {
+	for c.input.Len() < n {
+		if err := c.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------ implement netpoll.Reader ------------------------------------------
+
+// Next implements netpoll.Reader.
+func (c *Conn) Next(n int) (p []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	return c.input.Next(n)
+}
+
+// Peek implements netpoll.Reader.
+func (c *Conn) Peek(n int) (buf []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return buf, err
+	}
+	return c.input.Peek(n)
+}
+
+// Skip implements netpoll.Reader.
+func (c *Conn) Skip(n int) (err error) {
+	if err = c.waitRead(n); err != nil {
+		return err
+	}
+	return c.input.Skip(n)
+}
+
+// ReadString implements netpoll.Reader.
+func (c *Conn) ReadString(n int) (s string, err error) {
+	if err = c.waitRead(n); err != nil {
+		return s, err
+	}
+	return c.input.ReadString(n)
+}
+
+// ReadBinary implements netpoll.Reader.
+func (c *Conn) ReadBinary(n int) (p []byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	return c.input.ReadBinary(n)
+}
+
+// ReadByte implements netpoll.Reader.
+func (c *Conn) ReadByte() (b byte, err error) {
+	if err = c.waitRead(1); err != nil {
+		return b, err
+	}
+	return c.input.ReadByte()
+}
+
+// Slice implements netpoll.Reader.
+func (c *Conn) Slice(n int) (r netpoll.Reader, err error) {
+	if err = c.waitRead(n); err != nil {
+		return r, err
+	}
+	return c.input.Slice(n)
+}
+
+// Release implements netpoll.Reader.
+func (c *Conn) Release() (err error) {
+	return c.input.Release()
+}
+
+// Len implements netpoll.Reader.
+func (c *Conn) Len() (length int) {
+	return c.input.Len()
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err = c.waitRead(1); err != nil {
+		return 0, err
+	}
+	n = c.input.Len()
+	if n > len(b) {
+		n = len(b)
+	}
+	p, err := c.input.Next(n)
+	copy(b, p)
+	c.input.Release()
+	return n, err
+}
+
+// ------------------------------------------ implement netpoll.Writer ------------------------------------------
+
+// Malloc implements netpoll.Writer.
+func (c *Conn) Malloc(n int) (buf []byte, err error) {
+	return c.output.Malloc(n)
+}
+
+// WriteString implements netpoll.Writer.
+func (c *Conn) WriteString(s string) (n int, err error) {
+	return c.output.WriteString(s)
+}
+
+// WriteBinary implements netpoll.Writer.
+func (c *Conn) WriteBinary(b []byte) (n int, err error) {
+	return c.output.WriteBinary(b)
+}
+
+// WriteByte implements netpoll.Writer.
+func (c *Conn) WriteByte(b byte) (err error) {
+	return c.output.WriteByte(b)
+}
+
+// WriteDirect implements netpoll.Writer.
+func (c *Conn) WriteDirect(p []byte, remainCap int) error {
+	return c.output.WriteDirect(p, remainCap)
+}
+
+// MallocAck implements netpoll.Writer.
+func (c *Conn) MallocAck(n int) (err error) {
+	return c.output.MallocAck(n)
+}
+
+// Append implements netpoll.Writer.
+func (c *Conn) Append(w netpoll.Writer) (n int, err error) {
+	return c.output.Append(w)
+}
+
+// Flush implements netpoll.Writer, which encrypts the plaintext into records and sends them.
+func (c *Conn) Flush() (err error) {
+	c.output.Flush()
+	for l := c.output.Len(); l > 0; l = c.output.Len() {
+		if l > maxPlaintext {
+			l = maxPlaintext
+		}
+		p, _ := c.output.Next(l)
+		if _, err = c.conn.Write(p); err != nil {
+			break
+		}
+	}
+	c.output.Release()
+	return err
+}
+
+// MallocLen implements netpoll.Writer.
+func (c *Conn) MallocLen() (length int) {
+	return c.output.MallocLen()
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	if err = c.Flush(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+// ------------------------------------------ private ------------------------------------------
+
+// errWouldBlock is returned by rawConn.Read in nonblocking mode if no data is available.
+// It's temporary, so tls.Conn keeps the record partially read, and continues it on the next Read.
+var errWouldBlock net.Error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "tls: record not received completely" }
+func (wouldBlockError) Timeout() bool   { return true }
+func (wouldBlockError) Temporary() bool { return true }
+
+// rawConn adapts the underlying connection for tls.Conn, which reads at most one record at a time,
+// so that all the bytes buffered by tls.Conn are visible as complete records.
+type rawConn struct {
+	netpoll.Connection
+	nonblock bool                  // return errWouldBlock instead of waiting, used in OnRequest
+	hdr      [recordHeaderLen]byte // the header of the current record
+	hdrLen   int                   // bytes of hdr read
+	remain   int                   // bytes remaining of the body of the current record
+}
+
+// Read implements net.Conn, which blocks until some bytes of the current record are available,
+// or returns errWouldBlock in nonblocking mode.
+func (r *rawConn) Read(p []byte) (n int, err error) {
+	var reader = r.Connection.Reader()
+	if reader.Len() == 0 {
+		if r.nonblock {
+			return 0, errWouldBlock
+		}
+		if _, err = reader.Peek(1); err != nil {
+			return 0, err
+		}
+	}
+	// the header, then the body of the record
+	var limit = r.remain
+	if limit == 0 {
+		limit = recordHeaderLen - r.hdrLen
+	}
+	n = reader.Len()
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > limit {
+		n = limit
+	}
+	buf, err := reader.Next(n)
+	if err != nil {
+		return 0, err
+	}
+	copy(p, buf)
+	if r.remain > 0 {
+		r.remain -= n
+	} else if r.hdrLen += copy(r.hdr[r.hdrLen:], buf); r.hdrLen == recordHeaderLen {
+		r.hdrLen = 0
+		r.remain = int(binary.BigEndian.Uint16(r.hdr[3:]))
+	}
+	return n, reader.Release()
+}
+
+// Write implements net.Conn, which sends p immediately since it is reused by tls.Conn.
+func (r *rawConn) Write(p []byte) (n int, err error) {
+	var writer = r.Connection.Writer()
+	buf, err := writer.Malloc(len(p))
+	if err != nil {
+		return 0, err
+	}
+	copy(buf, p)
+	if err = writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Package tls provides TLS over netpoll.Connection, keeping the nocopy Reader and Writer.
+ *
+ * NewEventLoop: create an EventLoop serving TLS, OnRequest reads plaintext from the Conn.
+ * OnPrepare: wrap the accepted connections as TLS server-side Conn, used by netpoll.WithOnPrepare.
+ * Dial: connect to the server and run the handshake.
+ */
+
+// NewEventLoop creates an EventLoop serving TLS with config, and onRequest is called with *Conn
+// when plaintext is available. It uses netpoll.WithOnPrepare internally, so use OnPrepare instead
+// if a custom OnPrepare is needed.
+func NewEventLoop(config *tls.Config, onRequest netpoll.OnRequest, ops ...netpoll.Option) (netpoll.EventLoop, error) {
+	ops = append(ops, netpoll.WithOnPrepare(OnPrepare(config, onRequest, nil)))
+	return netpoll.NewEventLoop(onRequest, ops...)
+}
+
+// OnPrepare returns netpoll.OnPrepare which wraps each accepted connection as a TLS server-side Conn,
+// and sets onRequest to handle its plaintext. The handshake is started when ClientHello arrives,
+// before onRequest is called.
+//
+// The optional prepare is called with the Conn, which returns the context of onRequest.
+func OnPrepare(config *tls.Config, onRequest netpoll.OnRequest, prepare netpoll.OnPrepare) netpoll.OnPrepare {
+	return func(connection netpoll.Connection) context.Context {
+		var c = Server(connection, config)
+		c.SetOnRequest(onRequest)
+		if prepare != nil {
+			return prepare(c)
+		}
+		return context.Background()
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+// newTestConfig returns the server and client config with a self-signed certificate.
+func newTestConfig(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	MustNil(t, err)
+	var template = &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "netpoll"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	MustNil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	MustNil(t, err)
+	var pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	client = &tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+	}
+	return server, client
+}
+
+// echo writes back the plaintext received.
+func echo(ctx context.Context, connection netpoll.Connection) error {
+	var reader, writer = connection.Reader(), connection.Writer()
+	var p, err = reader.Next(reader.Len())
+	if err != nil {
+		return err
+	}
+	writer.WriteBinary(p)
+	err = writer.Flush()
+	reader.Release()
+	return err
+}
+
+func newTestEventLoop(t *testing.T, address string, config *tls.Config) netpoll.EventLoop {
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(config, echo)
+	MustNil(t, err)
+	go loop.Serve(ln)
+	return loop
+}
+
+func TestConnEcho(t *testing.T) {
+	var address = "127.0.0.1:8893"
+	var serverConfig, clientConfig = newTestConfig(t)
+	var loop = newTestEventLoop(t, address, serverConfig)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := Dial("tcp", address, time.Second, clientConfig)
+	MustNil(t, err)
+	defer conn.Close()
+	MustTrue(t, conn.ConnectionState().HandshakeComplete)
+
+	// pipelined small messages
+	var msgs = []string{"hello", "netpoll", "tls"}
+	for _, msg := range msgs {
+		conn.Writer().WriteString(msg)
+		MustNil(t, conn.Writer().Flush())
+	}
+	for _, msg := range msgs {
+		s, err := conn.Reader().ReadString(len(msg))
+		MustNil(t, err)
+		Equal(t, s, msg)
+	}
+
+	// large message across records
+	var big = make([]byte, 100*1024)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	buf, _ := conn.Writer().Malloc(len(big))
+	copy(buf, big)
+	MustNil(t, conn.Writer().Flush())
+	p, err := conn.Reader().Next(len(big))
+	MustNil(t, err)
+	Equal(t, string(p), string(big))
+	MustNil(t, conn.Reader().Release())
+}
+
+func TestConnInterop(t *testing.T) {
+	var address = "127.0.0.1:8894"
+	var serverConfig, clientConfig = newTestConfig(t)
+	var loop = newTestEventLoop(t, address, serverConfig)
+	defer loop.Shutdown(context.Background())
+
+	// crypto/tls client with netpoll server
+	conn, err := tls.Dial("tcp", address, clientConfig)
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	var buf = make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+
+	// netpoll client with crypto/tls server
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	client, err := Dial("tcp", ln.Addr().String(), time.Second, clientConfig)
+	MustNil(t, err)
+	defer client.Close()
+	var trigger = make(chan string, 1)
+	MustNil(t, client.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+		s, err := connection.Reader().ReadString(4)
+		if err == nil {
+			trigger <- s
+		}
+		return err
+	}))
+	_, err = client.Write([]byte("pong"))
+	MustNil(t, err)
+	Equal(t, <-trigger, "pong")
+}
+
+func TestConnHandshakeFailed(t *testing.T) {
+	var address = "127.0.0.1:8895"
+	var serverConfig, _ = newTestConfig(t)
+	var closed = make(chan struct{})
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(echo, netpoll.WithOnPrepare(OnPrepare(serverConfig, echo,
+		func(connection netpoll.Connection) context.Context {
+			connection.AddCloseCallback(func(connection netpoll.Connection) error {
+				close(closed)
+				return nil
+			})
+			return context.Background()
+		})))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// untrusted certificate
+	_, err = Dial("tcp", address, time.Second, &tls.Config{ServerName: "127.0.0.1"})
+	MustTrue(t, err != nil)
+	// the server side is closed by the failed handshake.
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server connection is not closed")
+	}
+}
+
+// splitConn writes the first n bytes of the next write only, and the rest is written by writePending.
+type splitConn struct {
+	net.Conn
+	n       int
+	pending []byte
+}
+
+func (c *splitConn) Write(p []byte) (int, error) {
+	if c.n <= 0 || c.n >= len(p) {
+		return c.Conn.Write(p)
+	}
+	c.pending = append([]byte{}, p[c.n:]...)
+	_, err := c.Conn.Write(p[:c.n])
+	c.n = 0
+	return len(p), err
+}
+
+func (c *splitConn) writePending() error {
+	_, err := c.Conn.Write(c.pending)
+	c.pending = nil
+	return err
+}
+
+func TestConnPartialRecord(t *testing.T) {
+	var address = "127.0.0.1:8929"
+	var serverConfig, clientConfig = newTestConfig(t)
+	var loop = newTestEventLoop(t, address, serverConfig)
+	defer loop.Shutdown(context.Background())
+
+	raw, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	var sc = &splitConn{Conn: raw}
+	var conn = tls.Client(sc, clientConfig)
+	defer conn.Close()
+	MustNil(t, conn.Handshake())
+
+	var inspector = loop.(netpoll.Inspector)
+	// split in the header and in the body of the record
+	for _, n := range []int{3, 10} {
+		sc.n = n
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		// OnRequest returns without waiting for the rest of the record
+		var deadline = time.Now().Add(time.Second)
+		for {
+			var infos = inspector.Connections()
+			if len(infos) == 1 && !infos[0].Processing && infos[0].InputLen == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("OnRequest is blocked by the partial record", infos)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		MustNil(t, sc.writePending())
+		var buf = make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// OpCode is the opcode of frames.
+type OpCode byte
+
+// The opcodes defined by RFC 6455.
+const (
+	OpContinuation OpCode = 0x0
+	OpText         OpCode = 0x1
+	OpBinary       OpCode = 0x2
+	OpClose        OpCode = 0x8
+	OpPing         OpCode = 0x9
+	OpPong         OpCode = 0xA
+)
+
+// The close codes defined by RFC 6455.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// maxControlPayload is the maximum payload size of control frames.
+const maxControlPayload = 125
+
+// ErrClosed is returned when writing after the close frame has been sent.
+var ErrClosed = errors.New("websocket: connection has been closed")
+
+// Conn is a WebSocket connection over netpoll.Connection.
+// The messages can be written by multiple goroutines, but the Reader and Writer of the underlying
+// connection must not be used directly after upgraded.
+type Conn struct {
+	netpoll.Connection
+	opts        options
+	onMessage   OnMessage
+	req         *http.Request
+	subprotocol string
+	upgraded    bool
+	mu          sync.Mutex // serializes writing frames
+	closeSent   bool
+}
+
+// closeError closes the connection with code.
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.code) + " " + e.reason
+}
+
+// Request returns the upgrade request, whose body has been consumed.
+func (c *Conn) Request() *http.Request {
+	return c.req
+}
+
+// Subprotocol returns the subprotocol selected, or empty if none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// WriteMessage writes p as a message of op in one frame, and flushes it.
+// p is appended without copying if it's large, so it must not be modified until returned.
+func (c *Conn) WriteMessage(op OpCode, p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeFrame(op, p)
+}
+
+// Ping writes a ping frame with payload p, which is at most 125 bytes.
+func (c *Conn) Ping(p []byte) error {
+	if len(p) > maxControlPayload {
+		return errors.New("websocket: control frame too large")
+	}
+	return c.WriteMessage(OpPing, p)
+}
+
+// CloseWithCode sends the close frame with code and reason, then closes the connection
+// without waiting for the close frame of the peer.
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	c.writeClose(code, reason)
+	return c.Connection.Close()
+}
+
+// writeClose writes the close frame once.
+func (c *Conn) writeClose(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	var p []byte
+	if code != CloseNoStatus {
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		p = make([]byte, 2+len(reason))
+		binary.BigEndian.PutUint16(p, uint16(code))
+		copy(p[2:], reason)
+	}
+	var err = c.writeFrame(OpClose, p)
+	c.closeSent = true
+	return err
+}
+
+// writeFrame writes an unmasked final frame, the header is written in the space allocated by Malloc.
+func (c *Conn) writeFrame(op OpCode, p []byte) error {
+	if c.closeSent {
+		return ErrClosed
+	}
+	var w = c.Writer()
+	var size = 2
+	switch {
+	case len(p) > 0xFFFF:
+		size += 8
+	case len(p) > 125:
+		size += 2
+	}
+	header, err := w.Malloc(size)
+	if err != nil {
+		return err
+	}
+	header[0] = 0x80 | byte(op)
+	switch size {
+	case 2:
+		header[1] = byte(len(p))
+	case 4:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(p)))
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(p)))
+	}
+	if len(p) > 0 {
+		w.WriteBinary(p)
+	}
+	return w.Flush()
+}
+
+// onRequest runs the handshake first, then reads frames until a message is completed and delivered.
+func (c *Conn) onRequest(ctx context.Context, connection netpoll.Connection) (err error) {
+	var reader = c.Reader()
+	defer reader.Release()
+	if !c.upgraded {
+		return c.handshake()
+	}
+	op, msg, err := c.readMessage()
+	if err != nil {
+		if ce, ok := err.(*closeError); ok {
+			c.CloseWithCode(ce.code, ce.reason)
+		} else {
+			c.Connection.Close()
+		}
+		return err
+	}
+	if op == OpClose {
+		return nil
+	}
+	if err = c.onMessage(ctx, c, op, msg); err != nil {
+		c.CloseWithCode(CloseInternalError, "")
+	}
+	return err
+}
+
+// readMessage reads frames until a data message is completed, the control frames between them are handled.
+// op is OpClose if the close frame is received, and the connection has been closed.
+func (c *Conn) readMessage() (op OpCode, msg []byte, err error) {
+	var fragmented bool
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case OpPing:
+			c.mu.Lock()
+			c.writeFrame(OpPong, payload)
+			c.mu.Unlock()
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return OpClose, nil, c.onClose(payload)
+		case OpText, OpBinary:
+			if fragmented {
+				return 0, nil, &closeError{code: CloseProtocolError, reason: "expect continuation frame"}
+			}
+			op, msg = frameOp, payload
+		case OpContinuation:
+			if !fragmented {
+				return 0, nil, &closeError{code: CloseProtocolError, reason: "unexpected continuation frame"}
+			}
+			if len(msg)+len(payload) > c.opts.maxMessageSize {
+				return 0, nil, &closeError{code: CloseMessageTooBig}
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, &closeError{code: CloseProtocolError, reason: "unknown opcode"}
+		}
+		if !fin {
+			// the payload is only valid until released, so the fragments are copied
+			if !fragmented {
+				fragmented = true
+				msg = append(make([]byte, 0, 2*len(msg)), msg...)
+			}
+			continue
+		}
+		if op == OpText && !utf8.Valid(msg) {
+			return 0, nil, &closeError{code: CloseInvalidPayload, reason: "invalid UTF-8"}
+		}
+		return op, msg, nil
+	}
+}
+
+// readFrame reads a frame from the nocopy Reader, and unmasks the payload in place.
+func (c *Conn) readFrame() (fin bool, op OpCode, payload []byte, err error) {
+	var reader = c.Reader()
+	header, err := reader.Next(2)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = header[0]&0x80 != 0, OpCode(header[0]&0x0F)
+	var masked, length = header[1]&0x80 != 0, int64(header[1] & 0x7F)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &closeError{code: CloseProtocolError, reason: "reserved bits set"}
+	}
+	if !masked {
+		return false, 0, nil, &closeError{code: CloseProtocolError, reason: "frame not masked"}
+	}
+	if op >= OpClose && (!fin || length > maxControlPayload) {
+		return false, 0, nil, &closeError{code: CloseProtocolError, reason: "invalid control frame"}
+	}
+	switch length {
+	case 126:
+		if header, err = reader.Next(2); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(header))
+	case 127:
+		if header, err = reader.Next(8); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(header))
+	}
+	if length < 0 || length > int64(c.opts.maxMessageSize) {
+		return false, 0, nil, &closeError{code: CloseMessageTooBig}
+	}
+	key, err := reader.Next(4)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	var mask [4]byte
+	copy(mask[:], key)
+	// zero-copy if the payload is in one node, and it's valid until released
+	if payload, err = reader.Next(int(length)); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return fin, op, payload, nil
+}
+
+// onClose echoes the close frame and closes the connection.
+func (c *Conn) onClose(payload []byte) error {
+	var code = CloseNoStatus
+	switch {
+	case len(payload) == 1:
+		return &closeError{code: CloseProtocolError, reason: "invalid close frame"}
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		if !validCloseCode(code) {
+			return &closeError{code: CloseProtocolError, reason: "invalid close code"}
+		}
+		if !utf8.Valid(payload[2:]) {
+			return &closeError{code: CloseInvalidPayload, reason: "invalid UTF-8"}
+		}
+	}
+	c.CloseWithCode(code, "")
+	return nil
+}
+
+// validCloseCode reports whether the close code received is allowed.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/netpoll"
+	"github.com/cloudwego/netpoll/http1"
+)
+
+/* DOC:
+ * Package websocket implements the server side of WebSocket (RFC 6455) on netpoll.Connection.
+ *
+ * NewEventLoop: create an EventLoop serving WebSocket, the messages are delivered to OnMessage.
+ * OnPrepare: upgrade the accepted connections, used by netpoll.WithOnPrepare.
+ * Upgrade: take over the OnRequest of a connection, which runs the handshake when the request arrives.
+ *
+ * The frames are decoded from the nocopy Reader and unmasked in place, and a message is delivered once
+ * all its fragments are received. Ping is answered with Pong, and Close is echoed before closing the connection.
+ * The frames are written by Malloc for the header and the payload is appended without an extra copy.
+ * Extensions (e.g. permessage-deflate) are not supported.
+ */
+
+// DefaultMaxMessageSize is the default maximum size of messages, the connection is closed with
+// CloseMessageTooBig if exceeded.
+const DefaultMaxMessageSize = 16 << 20
+
+// acceptGUID is used to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var errHandshake = errors.New("websocket: bad handshake")
+
+// OnMessage is called with each complete message of Text or Binary, and msg is only valid until it returns.
+// The connection is closed with CloseInternalError if it returns an error.
+type OnMessage func(ctx context.Context, conn *Conn, op OpCode, msg []byte) error
+
+// Option .
+type Option struct {
+	f func(*options)
+}
+
+type options struct {
+	maxMessageSize int
+	checkOrigin    func(r *http.Request) bool
+	subprotocols   []string
+}
+
+// WithMaxMessageSize sets the maximum size of messages, including all the fragments.
+func WithMaxMessageSize(n int) Option {
+	return Option{func(op *options) {
+		op.maxMessageSize = n
+	}}
+}
+
+// WithCheckOrigin sets the function to check the Origin of handshake requests, which responds 403 if it returns false.
+// All origins are allowed by default.
+func WithCheckOrigin(checkOrigin func(r *http.Request) bool) Option {
+	return Option{func(op *options) {
+		op.checkOrigin = checkOrigin
+	}}
+}
+
+// WithSubprotocols sets the supported subprotocols in order of preference,
+// and the most preferred one requested by the client is selected.
+func WithSubprotocols(protocols ...string) Option {
+	return Option{func(op *options) {
+		op.subprotocols = protocols
+	}}
+}
+
+// NewEventLoop creates an EventLoop serving WebSocket, and onMessage is called with the messages.
+// opts configures the connections as Upgrade, and ops configures the EventLoop.
+// It uses netpoll.WithOnPrepare internally, so use OnPrepare instead if a custom OnPrepare is needed.
+func NewEventLoop(onMessage OnMessage, opts []Option, ops ...netpoll.Option) (netpoll.EventLoop, error) {
+	ops = append(ops, netpoll.WithOnPrepare(OnPrepare(onMessage, nil, opts...)))
+	return netpoll.NewEventLoop(nil, ops...)
+}
+
+// OnPrepare returns netpoll.OnPrepare which upgrades each accepted connection.
+//
+// The optional prepare is called with the Conn before the handshake, which returns the context of onMessage.
+func OnPrepare(onMessage OnMessage, prepare func(conn *Conn) context.Context, opts ...Option) netpoll.OnPrepare {
+	return func(connection netpoll.Connection) context.Context {
+		var c = Upgrade(connection, onMessage, opts...)
+		if prepare != nil {
+			return prepare(c)
+		}
+		return context.Background()
+	}
+}
+
+// Upgrade takes over the OnRequest of connection, which runs the handshake when the upgrade request arrives,
+// then delivers the messages to onMessage. It must be called before any data is received.
+func Upgrade(connection netpoll.Connection, onMessage OnMessage, opts ...Option) *Conn {
+	var c = &Conn{
+		Connection: connection,
+		onMessage:  onMessage,
+	}
+	c.opts.maxMessageSize = DefaultMaxMessageSize
+	for _, do := range opts {
+		do.f(&c.opts)
+	}
+	connection.SetOnRequest(c.onRequest)
+	return c
+}
+
+// handshake reads the upgrade request and responds 101 Switching Protocols,
+// otherwise the error is responded and the connection is closed.
+func (c *Conn) handshake() error {
+	req, err := http1.ReadRequest(c.Reader(), http1.DefaultMaxHeaderBytes)
+	if err != nil {
+		return c.reject(http.StatusBadRequest, "")
+	}
+	var key = req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet || !req.ProtoAtLeast(1, 1) ||
+		!hasToken(req.Header["Connection"], "upgrade") || !hasToken(req.Header["Upgrade"], "websocket"):
+		return c.reject(http.StatusBadRequest, "")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return c.reject(http.StatusUpgradeRequired, "Sec-WebSocket-Version: 13\r\n")
+	case !validKey(key):
+		return c.reject(http.StatusBadRequest, "")
+	case c.opts.checkOrigin != nil && !c.opts.checkOrigin(req):
+		return c.reject(http.StatusForbidden, "")
+	}
+	c.req = req
+	c.subprotocol = selectSubprotocol(req, c.opts.subprotocols)
+
+	var w = c.Writer()
+	w.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	w.WriteString(acceptKey(key))
+	if c.subprotocol != "" {
+		w.WriteString("\r\nSec-WebSocket-Protocol: ")
+		w.WriteString(c.subprotocol)
+	}
+	w.WriteString("\r\n\r\n")
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	c.upgraded = true
+	return nil
+}
+
+// reject responds the failed handshake and closes the connection.
+func (c *Conn) reject(code int, header string) error {
+	var w = c.Writer()
+	var text = http.StatusText(code)
+	w.WriteString("HTTP/1.1 " + strconv.Itoa(code) + " " + text + "\r\n" + header +
+		"Content-Length: " + strconv.Itoa(len(text)) + "\r\nConnection: close\r\n\r\n" + text)
+	w.Flush()
+	c.Connection.Close()
+	return errHandshake
+}
+
+// acceptKey computes Sec-WebSocket-Accept from Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	var h = sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// validKey reports whether key is the base64 of 16 bytes.
+func validKey(key string) bool {
+	p, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(p) == 16
+}
+
+// selectSubprotocol returns the most preferred subprotocol of the server requested by the client.
+func selectSubprotocol(req *http.Request, supported []string) string {
+	var requested = req.Header.Values("Sec-WebSocket-Protocol")
+	for _, s := range supported {
+		for _, v := range requested {
+			for _, p := range strings.Split(v, ",") {
+				if strings.TrimSpace(p) == s {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// hasToken reports whether the comma-separated header values contain token, case-insensitively.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
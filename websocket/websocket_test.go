@@ -0,0 +1,261 @@
+// Copyright 2021 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func newTestEventLoop(t *testing.T, address string, onMessage OnMessage, opts ...Option) {
+	ln, err := netpoll.CreateListener("tcp", address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(onMessage, opts)
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		loop.Shutdown(ctx)
+	})
+	time.Sleep(10 * time.Millisecond)
+}
+
+// echo writes the messages back.
+func echo(ctx context.Context, conn *Conn, op OpCode, msg []byte) error {
+	return conn.WriteMessage(op, msg)
+}
+
+// testClient is a minimal client to test the server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dial(t *testing.T, address string, header string) (*testClient, *http.Response) {
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: server.example.com\r\nUpgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + header + "\r\n"))
+	MustNil(t, err)
+	var c = &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.br, nil)
+	MustNil(t, err)
+	return c, resp
+}
+
+func (c *testClient) writeFrame(fin bool, op OpCode, payload []byte, masked bool) {
+	var header = []byte{byte(op), 0}
+	if fin {
+		header[0] |= 0x80
+	}
+	switch {
+	case len(payload) > 0xFFFF:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	case len(payload) > 125:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header[1] = byte(len(payload))
+	}
+	var data = append([]byte{}, payload...)
+	if masked {
+		header[1] |= 0x80
+		var mask = []byte{byte(rand.Int()), byte(rand.Int()), byte(rand.Int()), byte(rand.Int())}
+		header = append(header, mask...)
+		for i := range data {
+			data[i] ^= mask[i&3]
+		}
+	}
+	_, err := c.conn.Write(append(header, data...))
+	MustNil(c.t, err)
+}
+
+func (c *testClient) readFrame() (op OpCode, payload []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var header = make([]byte, 2)
+	_, err := io.ReadFull(c.br, header)
+	MustNil(c.t, err)
+	MustTrue(c.t, header[0]&0x80 != 0 && header[1]&0x80 == 0)
+	var length = int(header[1])
+	switch length {
+	case 126:
+		var ext = make([]byte, 2)
+		_, err = io.ReadFull(c.br, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		var ext = make([]byte, 8)
+		_, err = io.ReadFull(c.br, ext)
+		length = int(binary.BigEndian.Uint64(ext))
+	}
+	MustNil(c.t, err)
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	MustNil(c.t, err)
+	return OpCode(header[0] & 0x0F), payload
+}
+
+func (c *testClient) readClose() int {
+	op, payload := c.readFrame()
+	Equal(c.t, op, OpClose)
+	// the connection is closed after the close frame
+	_, err := c.br.ReadByte()
+	MustTrue(c.t, err != nil)
+	if len(payload) < 2 {
+		return CloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestHandshake(t *testing.T) {
+	var address = "127.0.0.1:8917"
+	newTestEventLoop(t, address, echo,
+		WithSubprotocols("v2.chat", "chat"),
+		WithCheckOrigin(func(r *http.Request) bool {
+			return r.Header.Get("Origin") != "http://evil.example.com"
+		}))
+
+	c, resp := dial(t, address, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat, v2.chat\r\n")
+	Equal(t, resp.StatusCode, http.StatusSwitchingProtocols)
+	Equal(t, resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	Equal(t, resp.Header.Get("Sec-WebSocket-Protocol"), "v2.chat")
+	c.writeFrame(true, OpText, []byte("hello"), true)
+	op, payload := c.readFrame()
+	Equal(t, op, OpText)
+	Equal(t, string(payload), "hello")
+
+	_, resp = dial(t, address, "Sec-WebSocket-Version: 8\r\n")
+	Equal(t, resp.StatusCode, http.StatusUpgradeRequired)
+	Equal(t, resp.Header.Get("Sec-WebSocket-Version"), "13")
+	_, resp = dial(t, address, "Sec-WebSocket-Version: 13\r\nOrigin: http://evil.example.com\r\n")
+	Equal(t, resp.StatusCode, http.StatusForbidden)
+	MustTrue(t, resp.Close)
+}
+
+func TestMessages(t *testing.T) {
+	var address = "127.0.0.1:8918"
+	newTestEventLoop(t, address, echo)
+	c, resp := dial(t, address, "Sec-WebSocket-Version: 13\r\n")
+	Equal(t, resp.StatusCode, http.StatusSwitchingProtocols)
+
+	// 16-bit and 64-bit lengths
+	for _, size := range []int{200, 70000} {
+		var data = make([]byte, size)
+		rand.Read(data)
+		c.writeFrame(true, OpBinary, data, true)
+		op, payload := c.readFrame()
+		Equal(t, op, OpBinary)
+		Equal(t, string(payload), string(data))
+	}
+
+	// fragments with ping between them
+	c.writeFrame(false, OpText, []byte("hel"), true)
+	c.writeFrame(true, OpPing, []byte("ping"), true)
+	c.writeFrame(false, OpContinuation, []byte("lo, "), true)
+	c.writeFrame(true, OpContinuation, []byte("world"), true)
+	op, payload := c.readFrame()
+	Equal(t, op, OpPong)
+	Equal(t, string(payload), "ping")
+	op, payload = c.readFrame()
+	Equal(t, op, OpText)
+	Equal(t, string(payload), "hello, world")
+
+	// close is echoed
+	c.writeFrame(true, OpClose, []byte{0x03, 0xE8, 'b', 'y', 'e'}, true)
+	Equal(t, c.readClose(), CloseNormal)
+}
+
+func TestProtocolError(t *testing.T) {
+	var address = "127.0.0.1:8919"
+	newTestEventLoop(t, address, func(ctx context.Context, conn *Conn, op OpCode, msg []byte) error {
+		if string(msg) == "fail" {
+			return io.EOF
+		}
+		return echo(ctx, conn, op, msg)
+	}, WithMaxMessageSize(1024))
+
+	var cases = []struct {
+		frames func(c *testClient)
+		code   int
+	}{
+		{func(c *testClient) { c.writeFrame(true, OpText, []byte("unmasked"), false) }, CloseProtocolError},
+		{func(c *testClient) { c.writeFrame(true, OpText, []byte{0xFF, 0xFE}, true) }, CloseInvalidPayload},
+		{func(c *testClient) { c.writeFrame(true, OpBinary, make([]byte, 1025), true) }, CloseMessageTooBig},
+		{func(c *testClient) {
+			c.writeFrame(false, OpBinary, make([]byte, 1000), true)
+			c.writeFrame(true, OpContinuation, make([]byte, 25), true)
+		}, CloseMessageTooBig},
+		{func(c *testClient) { c.writeFrame(true, OpContinuation, []byte("x"), true) }, CloseProtocolError},
+		{func(c *testClient) { c.writeFrame(false, OpPing, nil, true) }, CloseProtocolError},
+		{func(c *testClient) { c.writeFrame(true, OpClose, []byte{0x03, 0xED}, true) }, CloseProtocolError},
+		{func(c *testClient) { c.writeFrame(true, OpClose, nil, true) }, CloseNoStatus},
+		{func(c *testClient) { c.writeFrame(true, OpText, []byte("fail"), true) }, CloseInternalError},
+	}
+	for i, tc := range cases {
+		c, _ := dial(t, address, "Sec-WebSocket-Version: 13\r\n")
+		tc.frames(c)
+		Assert(t, c.readClose() == tc.code, i)
+	}
+	MustTrue(t, strings.Contains((&closeError{code: CloseNormal}).Error(), "1000"))
+}